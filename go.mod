@@ -7,8 +7,11 @@ require (
 	github.com/anandvarma/namegen v0.0.0-20230727084436-5197c6ea3255
 	github.com/edgedb/edgedb-go v0.14.4
 	github.com/go-playground/validator/v10 v10.16.0
+	github.com/hajimehoshi/go-mp3 v0.3.4
 	github.com/labstack/echo/v4 v4.12.0
 	github.com/minio/minio-go/v7 v7.0.76
+	golang.org/x/image v0.19.0
+	golang.org/x/sys v0.24.0
 )
 
 require (
@@ -42,7 +45,6 @@ require (
 	golang.org/x/mod v0.17.0 // indirect
 	golang.org/x/net v0.28.0 // indirect
 	golang.org/x/sync v0.8.0 // indirect
-	golang.org/x/sys v0.24.0 // indirect
 	golang.org/x/text v0.17.0 // indirect
 	golang.org/x/time v0.5.0 // indirect
 	golang.org/x/tools v0.21.1-0.20240508182429-e35e4ccd0d2d // indirect
@@ -0,0 +1,186 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"time"
+	"world-sounds/models"
+
+	"github.com/edgedb/edgedb-go"
+	"github.com/labstack/echo/v4"
+)
+
+// Blocks suspended and banned users, telling them why
+func (h *Handler) RequireActiveAccount(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		authToken, ok := c.Get("authToken").(models.AuthToken)
+		if !ok {
+			return next(c)
+		}
+
+		var user *models.UserFetchResult
+		err := models.GetTx(h.DB, &authToken)(c.Request().Context(), func(ctx context.Context, tx *edgedb.Tx) error {
+			var err error
+			user, err = models.UserFetch(ctx, tx)
+			if err != nil {
+				return err
+			}
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("failed to fetch user: %w", err)
+		}
+
+		if user == nil || user.AccountStatus == "active" {
+			return next(c)
+		}
+
+		message := "Forbidden: account is " + user.AccountStatus
+		if suspendedUntil, ok := user.SuspendedUntil.Get(); ok {
+			message += " until " + suspendedUntil.UTC().Format(time.RFC3339)
+		}
+		if reason, ok := user.StatusReason.Get(); ok {
+			message += ": " + reason
+		}
+
+		return newEchoHTTPError(http.StatusForbidden, message, nil)
+	}
+}
+
+type AdminUserUpdateStatusData struct {
+	UserID         edgedb.UUID `param:"id" validate:"required"`
+	Status         string      `json:"status" validate:"required,oneof=active suspended banned"`
+	SuspendedUntil *time.Time  `json:"suspended_until"`
+	Reason         string      `json:"reason" validate:"max=500"`
+	RemoveBids     bool        `json:"remove_bids"`
+	RefundBids     bool        `json:"refund_bids"`
+}
+
+// Suspending or banning a user skips their airing stream and keeps their bids out of the queue and public feeds, or
+// removes the bids if asked to. Reactivating them releases bids held for them
+func (h *Handler) AdminUserUpdateStatus(c echo.Context) error {
+	authToken, err := GetAuthToken(c)
+	if err != nil {
+		return err
+	}
+
+	data, err := validateData[AdminUserUpdateStatusData](c)
+	if err != nil {
+		return err
+	}
+
+	var suspendedUntil edgedb.OptionalDateTime
+	var reason edgedb.OptionalStr
+	switch data.Status {
+	case "active":
+		if data.SuspendedUntil != nil || data.RemoveBids {
+			return newEchoHTTPError(http.StatusBadRequest, "suspended_until and remove_bids can't be used to reactivate a user", nil)
+		}
+	case "suspended":
+		if data.SuspendedUntil == nil || !data.SuspendedUntil.After(time.Now()) {
+			return newEchoHTTPError(http.StatusBadRequest, "suspended_until must be in the future", nil)
+		}
+		suspendedUntil = edgedb.NewOptionalDateTime(*data.SuspendedUntil)
+		fallthrough
+	case "banned":
+		if data.Reason == "" {
+			return newEchoHTTPError(http.StatusBadRequest, "reason is required", nil)
+		}
+		reason = edgedb.NewOptionalStr(data.Reason)
+	}
+
+	var skipped bool
+	err = models.GetTx(h.DB, authToken)(c.Request().Context(), func(ctx context.Context, tx *edgedb.Tx) error {
+		before, err := models.UserStatusFetch(ctx, tx, data.UserID)
+		if err != nil {
+			return err
+		}
+
+		// Moderators can't lock admins out, and nobody can lock themselves out or lift their own suspension
+		if before.IsCurrentUser {
+			return newEchoHTTPError(http.StatusForbidden, "Forbidden: can't change your own status", nil)
+		}
+		callerRoles, _ := c.Get("roles").([]string)
+		if slices.Contains(before.Roles, RoleAdmin) && !slices.Contains(callerRoles, RoleAdmin) {
+			return newEchoHTTPError(http.StatusForbidden, "Forbidden: only admins can change the status of admins", nil)
+		}
+
+		after := map[string]any{
+			"account_status":  data.Status,
+			"suspended_until": suspendedUntil,
+			"status_reason":   reason,
+		}
+
+		if data.Status == "active" {
+			releasedBids, err := models.BidsRelease(ctx, tx, data.UserID, h.ReportThreshold)
+			if err != nil {
+				return err
+			}
+			after["released_bids"] = releasedBids
+		} else {
+			// Looked up before the update, the feed stops showing the user's streams right after it
+			latest, err := models.StreamLastestFetch(ctx, tx, 0, 1)
+			if err != nil {
+				return err
+			}
+
+			if len(latest) == 1 && latest[0].User.ID == data.UserID {
+				skippedStream, err := models.StreamSkip(ctx, tx, edgedb.NewOptionalUUID(latest[0].ID))
+				if err != nil {
+					return err
+				}
+				skipped = skippedStream != nil
+			}
+			after["skipped"] = skipped
+		}
+
+		err = models.UserUpdateStatus(ctx, tx, data.UserID, data.Status, suspendedUntil, reason)
+		if err != nil {
+			return err
+		}
+
+		if data.RemoveBids {
+			bids, err := models.BidsRemoveByUser(ctx, tx, data.UserID)
+			if err != nil {
+				return err
+			}
+
+			var refundedCredits int64
+			if data.RefundBids {
+				for _, bid := range bids {
+					refundedCredits += bid.Credits
+				}
+				if refundedCredits > 0 {
+					_, err = models.UserIncrementCredits(ctx, tx, data.UserID, refundedCredits)
+					if err != nil {
+						return err
+					}
+				}
+			}
+
+			after["removed_bids"] = bids
+			after["refunded_credits"] = refundedCredits
+		}
+
+		return models.AuditEventCreate(ctx, tx, auditSource(c), "user.status", models.AuditTargetUser, edgedb.NewOptionalUUID(data.UserID), data.Reason, before, after)
+	})
+	if errors.Is(err, models.ErrUserNotFound) {
+		return newEchoHTTPError(http.StatusNotFound, "user does not exist", err)
+	}
+	var httpError *echo.HTTPError
+	if errors.As(err, &httpError) {
+		return httpError
+	}
+	if err != nil {
+		return fmt.Errorf("failed to update user status: %w", err)
+	}
+
+	if skipped {
+		h.wakeScheduler()
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
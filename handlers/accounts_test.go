@@ -0,0 +1,71 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"world-sounds/models"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/labstack/echo/v4"
+)
+
+type testValidator struct {
+	validator *validator.Validate
+}
+
+func (v testValidator) Validate(i interface{}) error {
+	return v.validator.Struct(i)
+}
+
+func TestAdminUserUpdateStatusProtectsAdminsAndSelf(t *testing.T) {
+	h := testHandler(t)
+	testDB(t, h)
+
+	admin := testUser(t, h, 0)
+	testSetRoles(t, h, admin, RoleAdmin)
+	moderator := testUser(t, h, 0)
+	testSetRoles(t, h, moderator, RoleModerator)
+	otherAdmin := testUser(t, h, 0)
+	testSetRoles(t, h, otherAdmin, RoleAdmin)
+	user := testUser(t, h, 0)
+
+	tests := []struct {
+		name        string
+		caller      models.AuthToken
+		callerRoles []string
+		target      models.AuthToken
+		wantCode    int
+	}{
+		{"moderator bans admin", moderator, []string{RoleModerator}, otherAdmin, http.StatusForbidden},
+		{"moderator bans themselves", moderator, []string{RoleModerator}, moderator, http.StatusForbidden},
+		{"admin bans themselves", admin, []string{RoleAdmin}, admin, http.StatusForbidden},
+		{"moderator bans user", moderator, []string{RoleModerator}, user, http.StatusNoContent},
+		{"admin bans admin", admin, []string{RoleAdmin}, otherAdmin, http.StatusNoContent},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPut, "/api/v1/admin/users/"+test.target.UserID.String()+"/status", strings.NewReader(`{"status": "banned", "reason": "test"}`))
+			req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+			rec := httptest.NewRecorder()
+
+			e := echo.New()
+			e.Validator = testValidator{validator.New()}
+			c := e.NewContext(req, rec)
+			c.SetParamNames("id")
+			c.SetParamValues(test.target.UserID.String())
+			c.Set("authToken", test.caller)
+			c.Set("roles", test.callerRoles)
+
+			code := rec.Code
+			if err := h.AdminUserUpdateStatus(c); err != nil {
+				code = httpErrorCode(t, err)
+			}
+			if code != test.wantCode {
+				t.Errorf("got %d, want %d: %s", code, test.wantCode, rec.Body)
+			}
+		})
+	}
+}
@@ -0,0 +1,145 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"world-sounds/models"
+
+	"github.com/edgedb/edgedb-go"
+	"github.com/labstack/echo/v4"
+)
+
+type AdminUsersFetchData struct {
+	Search string `query:"search"`
+	Offset int64  `query:"offset"`
+	Limit  int64  `query:"limit"`
+}
+
+func (h *Handler) AdminUsersFetch(c echo.Context) error {
+	authToken, err := GetAuthToken(c)
+	if err != nil {
+		return err
+	}
+
+	data, err := validateData[AdminUsersFetchData](c)
+	if err != nil {
+		return err
+	}
+
+	if data.Offset < 0 {
+		return newEchoHTTPError(http.StatusBadRequest, "offset must be greater than or equal to 0", nil)
+	}
+
+	if data.Limit == 0 {
+		data.Limit = 20
+	} else if data.Limit < 1 || data.Limit > 100 {
+		return newEchoHTTPError(http.StatusBadRequest, "limit must be between 1 and 100", nil)
+	}
+
+	var search edgedb.OptionalStr
+	if data.Search != "" {
+		search = edgedb.NewOptionalStr(data.Search)
+	}
+
+	var users []models.UsersFetchResult
+	err = models.GetTx(h.DB, authToken)(c.Request().Context(), func(ctx context.Context, tx *edgedb.Tx) error {
+		users, err = models.UsersFetch(ctx, tx, search, data.Offset, data.Limit)
+		if err != nil {
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to fetch users: %w", err)
+	}
+
+	return c.JSON(http.StatusOK, users)
+}
+
+type AdminUserUpdateRolesData struct {
+	UserID edgedb.UUID `param:"id" validate:"required"`
+	Roles  []string    `json:"roles" validate:"dive,oneof=admin moderator"`
+}
+
+func (h *Handler) AdminUserUpdateRoles(c echo.Context) error {
+	authToken, err := GetAuthToken(c)
+	if err != nil {
+		return err
+	}
+
+	data, err := validateData[AdminUserUpdateRolesData](c)
+	if err != nil {
+		return err
+	}
+
+	if data.Roles == nil {
+		data.Roles = []string{}
+	}
+
+	err = models.GetTx(h.DB, authToken)(c.Request().Context(), func(ctx context.Context, tx *edgedb.Tx) error {
+		previousRoles, err := models.UserUpdateRoles(ctx, tx, data.UserID, data.Roles)
+		if err != nil {
+			return err
+		}
+
+		return models.AuditEventCreate(ctx, tx, auditSource(c), "user.roles", models.AuditTargetUser, edgedb.NewOptionalUUID(data.UserID), "", map[string]any{
+			"roles": previousRoles,
+		}, map[string]any{
+			"roles": data.Roles,
+		})
+	})
+	if errors.Is(err, models.ErrUserNotFound) {
+		return newEchoHTTPError(http.StatusNotFound, "user does not exist", err)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to update roles: %w", err)
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+type AdminUserAdjustCreditsData struct {
+	UserID edgedb.UUID `param:"id" validate:"required"`
+	Amount int64       `json:"amount" validate:"required"`
+	Reason string      `json:"reason" validate:"required,max=500"`
+}
+
+// Adds the amount to the credits of the user, negative amounts take credits away
+func (h *Handler) AdminUserAdjustCredits(c echo.Context) error {
+	authToken, err := GetAuthToken(c)
+	if err != nil {
+		return err
+	}
+
+	data, err := validateData[AdminUserAdjustCreditsData](c)
+	if err != nil {
+		return err
+	}
+
+	err = models.GetTx(h.DB, authToken)(c.Request().Context(), func(ctx context.Context, tx *edgedb.Tx) error {
+		credits, err := models.UserIncrementCredits(ctx, tx, data.UserID, data.Amount)
+		if err != nil {
+			return err
+		}
+
+		return models.AuditEventCreate(ctx, tx, auditSource(c), "user.credits", models.AuditTargetUser, edgedb.NewOptionalUUID(data.UserID), data.Reason, map[string]any{
+			"credits": credits - data.Amount,
+		}, map[string]any{
+			"credits": credits,
+		})
+	})
+	if errors.Is(err, models.ErrUserNotFound) {
+		return newEchoHTTPError(http.StatusNotFound, "user does not exist", err)
+	}
+	var edgedbErr edgedb.Error
+	if errors.As(err, &edgedbErr) && edgedbErr.Category(edgedb.ConstraintViolationError) {
+		return newEchoHTTPError(http.StatusConflict, "credits can't go below 0", err)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to adjust credits: %w", err)
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
@@ -0,0 +1,54 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/labstack/echo/v4"
+)
+
+func TestAdminUsersFetch(t *testing.T) {
+	h := testHandler(t)
+	testDB(t, h)
+
+	admin := testUser(t, h, 0)
+	testSetRoles(t, h, admin, RoleAdmin)
+	user := testUser(t, h, 25)
+
+	var username string
+	err := h.DB.QuerySingle(context.Background(), `SELECT (SELECT User FILTER .id = <uuid>$id).username`, &username, map[string]interface{}{"id": user.UserID})
+	if err != nil {
+		t.Fatalf("failed to fetch username: %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/admin/users?search="+url.QueryEscape(username), nil)
+	rec := httptest.NewRecorder()
+	e := echo.New()
+	e.Validator = testValidator{validator.New()}
+	c := e.NewContext(req, rec)
+	c.Set("authToken", admin)
+	c.Set("roles", []string{RoleAdmin})
+
+	if err := h.AdminUsersFetch(c); err != nil {
+		t.Fatalf("handler failed: %v", err)
+	}
+	if rec.Code != http.StatusOK {
+		t.Fatalf("got %d, want %d: %s", rec.Code, http.StatusOK, rec.Body)
+	}
+
+	var users []struct {
+		ID      string `json:"id"`
+		Credits int64  `json:"credits"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &users); err != nil {
+		t.Fatal(err)
+	}
+	if len(users) != 1 || users[0].ID != user.UserID.String() || users[0].Credits != 25 {
+		t.Errorf("got users %+v, want only %s with 25 credits", users, username)
+	}
+}
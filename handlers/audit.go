@@ -0,0 +1,88 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+	"world-sounds/models"
+
+	"github.com/edgedb/edgedb-go"
+	"github.com/labstack/echo/v4"
+)
+
+type AdminAuditEventsFetchData struct {
+	Action     string      `query:"action" validate:"max=100"`
+	TargetType string      `query:"target_type" validate:"omitempty,oneof=Bid Stream SchedulerState Report User Deposit"`
+	TargetID   edgedb.UUID `query:"target_id"`
+	ActorID    edgedb.UUID `query:"actor_id"`
+	Since      time.Time   `query:"since"`
+	Until      time.Time   `query:"until"`
+	Offset     int64       `query:"offset"`
+	Limit      int64       `query:"limit"`
+}
+
+// Every filter is optional, since and until take RFC 3339 timestamps
+func (h *Handler) AdminAuditEventsFetch(c echo.Context) error {
+	authToken, err := GetAuthToken(c)
+	if err != nil {
+		return err
+	}
+
+	data, err := validateData[AdminAuditEventsFetchData](c)
+	if err != nil {
+		return err
+	}
+
+	if data.Offset < 0 {
+		return newEchoHTTPError(http.StatusBadRequest, "offset must be greater than or equal to 0", nil)
+	}
+
+	if data.Limit == 0 {
+		data.Limit = 50
+	} else if data.Limit < 1 || data.Limit > 200 {
+		return newEchoHTTPError(http.StatusBadRequest, "limit must be between 1 and 200", nil)
+	}
+
+	if !data.Since.IsZero() && !data.Until.IsZero() && !data.Since.Before(data.Until) {
+		return newEchoHTTPError(http.StatusBadRequest, "since must be before until", nil)
+	}
+
+	var action, targetType edgedb.OptionalStr
+	if data.Action != "" {
+		action = edgedb.NewOptionalStr(data.Action)
+	}
+	if data.TargetType != "" {
+		targetType = edgedb.NewOptionalStr(data.TargetType)
+	}
+
+	var targetID, actorID edgedb.OptionalUUID
+	if data.TargetID != (edgedb.UUID{}) {
+		targetID = edgedb.NewOptionalUUID(data.TargetID)
+	}
+	if data.ActorID != (edgedb.UUID{}) {
+		actorID = edgedb.NewOptionalUUID(data.ActorID)
+	}
+
+	var since, until edgedb.OptionalDateTime
+	if !data.Since.IsZero() {
+		since = edgedb.NewOptionalDateTime(data.Since)
+	}
+	if !data.Until.IsZero() {
+		until = edgedb.NewOptionalDateTime(data.Until)
+	}
+
+	var auditEvents []models.AuditEventsFetchResult
+	err = models.GetTx(h.DB, authToken)(c.Request().Context(), func(ctx context.Context, tx *edgedb.Tx) error {
+		auditEvents, err = models.AuditEventsFetch(ctx, tx, action, targetType, targetID, actorID, since, until, data.Offset, data.Limit)
+		if err != nil {
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to fetch audit events: %w", err)
+	}
+
+	return c.JSON(http.StatusOK, auditEvents)
+}
@@ -5,17 +5,67 @@ import (
 	"crypto/rand"
 	"crypto/sha256"
 	"crypto/tls"
+	"crypto/x509"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"log/slog"
 	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"time"
 	"world-sounds/models"
 
 	"github.com/edgedb/edgedb-go"
 	"github.com/labstack/echo/v4"
 )
 
+const (
+	authVerifierCookieName   = "edgedb-pkce-verifier"
+	authStateCookieName      = "auth-state"
+	authRedirectToCookieName = "auth-redirect-to"
+
+	// Long enough to sign in or sign up, including email verification in another tab
+	authFlowMaxAge = 15 * time.Minute
+)
+
+// Error codes added as the auth_error query parameter when redirecting back to the app
+const (
+	authErrorInvalidState = "invalid_state"
+	authErrorDenied       = "access_denied"
+	authErrorFailed       = "sign_in_failed"
+)
+
+// Trusts the system roots, and the certificates in the CA bundle if one is given, to reach the private auth URL
+func newAuthHTTPClient(caBundlePath string) (*http.Client, error) {
+	rootCAs, err := x509.SystemCertPool()
+	if err != nil {
+		rootCAs = x509.NewCertPool()
+	}
+
+	if caBundlePath != "" {
+		caBundle, err := os.ReadFile(caBundlePath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA bundle: %w", err)
+		}
+		if !rootCAs.AppendCertsFromPEM(caBundle) {
+			return nil, fmt.Errorf("no certificates found in CA bundle: %s", caBundlePath)
+		}
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = &tls.Config{RootCAs: rootCAs}
+
+	return &http.Client{
+		Transport: transport,
+		Timeout:   10 * time.Second,
+	}, nil
+}
+
 func generatePKCE() (string, string, error) {
 	verifier := make([]byte, 32)
 	if _, err := rand.Read(verifier); err != nil {
@@ -33,60 +83,168 @@ func generatePKCE() (string, string, error) {
 	return encodedVerifier, challenge, nil
 }
 
-func (h *Handler) AuthSignIn(c echo.Context) error {
-	verifier, challenge, err := generatePKCE()
-	if err != nil {
-		return fmt.Errorf("failed to generate PKCE: %w", err)
+func generateState() (string, error) {
+	state := make([]byte, 16)
+	if _, err := rand.Read(state); err != nil {
+		return "", fmt.Errorf("failed to read random bytes: %w", err)
 	}
+	return base64.RawURLEncoding.EncodeToString(state), nil
+}
 
-	redirectURL := fmt.Sprintf("%s/ui/signin?challenge=%s", h.AuthPublicBaseURL, challenge)
+func setAuthFlowCookie(c echo.Context, name string, value string) {
+	c.SetCookie(&http.Cookie{
+		Name:     name,
+		Value:    value,
+		HttpOnly: true,
+		Path:     "/",
+		Secure:   true,
+		SameSite: http.SameSiteLaxMode,
+		MaxAge:   int(authFlowMaxAge.Seconds()),
+	})
+}
 
+func clearAuthFlowCookie(c echo.Context, name string) {
 	c.SetCookie(&http.Cookie{
-		Name:     "edgedb-pkce-verifier",
-		Value:    verifier,
+		Name:     name,
+		Value:    "",
 		HttpOnly: true,
 		Path:     "/",
 		Secure:   true,
 		SameSite: http.SameSiteLaxMode,
+		MaxAge:   -1,
 	})
+}
 
-	return c.Redirect(http.StatusFound, redirectURL)
+// Only paths on this origin and URLs on the allowed origins may be redirected to after signing in, so the sign in
+// link can't be used as an open redirect
+func (h *Handler) isAllowedRedirect(redirectTo string) bool {
+	if strings.HasPrefix(redirectTo, "/") {
+		return !strings.HasPrefix(redirectTo, "//") && !strings.HasPrefix(redirectTo, "/\\")
+	}
+
+	redirectToURL, err := url.Parse(redirectTo)
+	if err != nil || redirectToURL.Host == "" {
+		return false
+	}
+	return slices.Contains(h.AllowedOrigins, redirectToURL.Scheme+"://"+redirectToURL.Host)
+}
+
+type AuthSignInData struct {
+	RedirectTo string `query:"redirect_to"`
 }
 
+func (h *Handler) AuthSignIn(c echo.Context) error {
+	data, err := validateData[AuthSignInData](c)
+	if err != nil {
+		return err
+	}
+
+	redirectTo := "/"
+	if data.RedirectTo != "" {
+		if !h.isAllowedRedirect(data.RedirectTo) {
+			return newEchoHTTPError(http.StatusBadRequest, "redirect_to must be a path or a URL on an allowed origin", nil)
+		}
+		redirectTo = data.RedirectTo
+	}
+
+	verifier, challenge, err := generatePKCE()
+	if err != nil {
+		return fmt.Errorf("failed to generate PKCE: %w", err)
+	}
+
+	state, err := generateState()
+	if err != nil {
+		return fmt.Errorf("failed to generate state: %w", err)
+	}
+
+	setAuthFlowCookie(c, authVerifierCookieName, verifier)
+	setAuthFlowCookie(c, authStateCookieName, state)
+	setAuthFlowCookie(c, authRedirectToCookieName, redirectTo)
+
+	query := url.Values{
+		"challenge": {challenge},
+		"state":     {state},
+	}
+	return c.Redirect(http.StatusFound, fmt.Sprintf("%s/ui/signin?%s", h.AuthPublicBaseURL, query.Encode()))
+}
+
+// Redirects back to the app whether the sign in succeeded or not, with the auth_error query parameter set on failure
 func (h *Handler) AuthCallback(c echo.Context) error {
+	redirectTo := "/"
+	if cookie, err := c.Cookie(authRedirectToCookieName); err == nil && h.isAllowedRedirect(cookie.Value) {
+		redirectTo = cookie.Value
+	}
+
+	verifierCookie, verifierErr := c.Cookie(authVerifierCookieName)
+	stateCookie, stateErr := c.Cookie(authStateCookieName)
+
+	// The flow can only be completed once
+	clearAuthFlowCookie(c, authVerifierCookieName)
+	clearAuthFlowCookie(c, authStateCookieName)
+	clearAuthFlowCookie(c, authRedirectToCookieName)
+
+	if authError := c.QueryParam("error"); authError != "" {
+		slog.Info("Sign in denied", slog.String("error", authError), slog.String("errorDescription", c.QueryParam("error_description")))
+		return authErrorRedirect(c, redirectTo, authErrorDenied)
+	}
+
+	// The state ties the callback to the browser that started the flow, a callback without it is never trusted
+	if verifierErr != nil || stateErr != nil || stateCookie.Value == "" || c.QueryParam("state") != stateCookie.Value {
+		return authErrorRedirect(c, redirectTo, authErrorInvalidState)
+	}
+
 	code := c.QueryParam("code")
+	if code == "" {
+		return authErrorRedirect(c, redirectTo, authErrorInvalidState)
+	}
 
-	cookie, err := c.Cookie("edgedb-pkce-verifier")
+	err := h.signIn(c, code, verifierCookie.Value)
 	if err != nil {
-		return fmt.Errorf("failed to get cookie: %w", err)
+		slog.Error("Failed to sign in", slog.Any("err", err))
+		return authErrorRedirect(c, redirectTo, authErrorFailed)
 	}
 
-	url := fmt.Sprintf("%s/token?code=%s&verifier=%s", h.AuthPrivateBaseURL, code, cookie.Value)
+	return c.Redirect(http.StatusFound, redirectTo)
+}
 
-	request, err := http.NewRequestWithContext(c.Request().Context(), http.MethodGet, url, nil)
+func authErrorRedirect(c echo.Context, redirectTo string, authError string) error {
+	redirectToURL, err := url.Parse(redirectTo)
 	if err != nil {
-		return fmt.Errorf("failed to create request: %w", err)
+		return fmt.Errorf("failed to parse redirect URL: %w", err)
 	}
 
-	// TODO: don't skip TLS verification, use http.DefaultClient.Do(request) instead. Fine for now as the request is sent via the private network
-	transport := http.DefaultTransport.(*http.Transport).Clone()
-	transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
-	client := &http.Client{
-		Transport: transport,
+	query := redirectToURL.Query()
+	query.Set("auth_error", authError)
+	redirectToURL.RawQuery = query.Encode()
+
+	return c.Redirect(http.StatusFound, redirectToURL.String())
+}
+
+func (h *Handler) exchangeAuthCode(ctx context.Context, code string, verifier string) (string, error) {
+	query := url.Values{
+		"code":     {code},
+		"verifier": {verifier},
+	}
+	tokenURL := fmt.Sprintf("%s/token?%s", h.AuthPrivateBaseURL, query.Encode())
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, tokenURL, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to create request: %w", err)
 	}
-	response, err := client.Do(request)
+
+	response, err := h.AuthHTTPClient.Do(request)
 	if err != nil {
-		return fmt.Errorf("failed to send request: %w", err)
+		return "", fmt.Errorf("failed to send request: %w", err)
 	}
 	defer response.Body.Close()
 
 	responseBytes, err := io.ReadAll(response.Body)
 	if err != nil {
-		return fmt.Errorf("failed to read response body: %w", err)
+		return "", fmt.Errorf("failed to read response body: %w", err)
 	}
 
 	if response.StatusCode != http.StatusOK {
-		return fmt.Errorf("unexpected status code: %d - `%s`", response.StatusCode, string(responseBytes))
+		return "", fmt.Errorf("unexpected status code: %d - `%s`", response.StatusCode, string(responseBytes))
 	}
 
 	var responseJSON struct {
@@ -94,33 +252,59 @@ func (h *Handler) AuthCallback(c echo.Context) error {
 	}
 	err = json.Unmarshal(responseBytes, &responseJSON)
 	if err != nil {
-		return err
+		return "", fmt.Errorf("failed to unmarshal response body: %w", err)
+	}
+
+	if responseJSON.AuthToken == "" {
+		return "", errors.New("auth token missing from response")
+	}
+
+	return responseJSON.AuthToken, nil
+}
+
+// Exchanges the code for an auth token, creates the user on the first sign in and starts the session
+func (h *Handler) signIn(c echo.Context, code string, verifier string) error {
+	clientToken, err := h.exchangeAuthCode(c.Request().Context(), code, verifier)
+	if err != nil {
+		return fmt.Errorf("failed to exchange code: %w", err)
 	}
 
-	err = models.GetTx(h.DB, &responseJSON.AuthToken)(c.Request().Context(), func(ctx context.Context, tx *edgedb.Tx) error {
+	authToken := &models.AuthToken{ClientToken: clientToken}
+
+	var createdUserID string
+	err = models.GetTx(h.DB, authToken)(c.Request().Context(), func(ctx context.Context, tx *edgedb.Tx) error {
 		user, err := models.UserFetch(ctx, tx)
 		if err != nil {
 			return err
 		}
 
 		if user == nil {
-			return models.UserCreate(ctx, tx)
+			createdUserID, err = models.UserCreate(ctx, tx)
+			if err != nil {
+				return err
+			}
+		}
+
+		_, err = models.SessionCreate(ctx, tx, hashAuthToken(clientToken), c.Request().UserAgent(), c.RealIP(), time.Now().Add(h.SessionMaxAge))
+		if err != nil {
+			return err
 		}
 
 		return nil
 	})
 	if err != nil {
-		return err
+		return fmt.Errorf("failed to create session: %w", err)
 	}
 
-	c.SetCookie(&http.Cookie{
-		Name:     "edgedb-auth-token",
-		Value:    responseJSON.AuthToken,
-		HttpOnly: true,
-		Path:     "/",
-		Secure:   true,
-		SameSite: http.SameSiteLaxMode,
-	})
+	// A missing default avatar shouldn't stop the sign in, the user can still reset to it later
+	if createdUserID != "" {
+		_, err = h.setGeneratedImage(c.Request().Context(), authToken, createdUserID)
+		if err != nil {
+			slog.Error("Failed to set generated avatar", slog.Any("err", err), slog.String("userID", createdUserID))
+		}
+	}
+
+	h.setAuthTokenCookie(c, clientToken)
 
-	return c.JSON(http.StatusOK, http.StatusText(http.StatusOK))
+	return nil
 }
@@ -0,0 +1,60 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+)
+
+func TestAuthCallbackRequiresState(t *testing.T) {
+	// Rejects every code exchange, so a callback that gets past the state check fails to sign in
+	authServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "invalid code", http.StatusForbidden)
+	}))
+	defer authServer.Close()
+
+	h := &Handler{AuthPrivateBaseURL: authServer.URL, AuthHTTPClient: authServer.Client()}
+
+	tests := []struct {
+		name          string
+		stateCookie   string
+		state         string
+		wantAuthError string
+	}{
+		{"missing state", "expected-state", "", authErrorInvalidState},
+		{"mismatched state", "expected-state", "forged-state", authErrorInvalidState},
+		{"missing state cookie", "", "forged-state", authErrorInvalidState},
+		{"matching state", "expected-state", "expected-state", authErrorFailed},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			query := url.Values{"code": {"code"}}
+			if test.state != "" {
+				query.Set("state", test.state)
+			}
+
+			req := httptest.NewRequest(http.MethodGet, "/api/v1/auth/callback?"+query.Encode(), nil)
+			req.AddCookie(&http.Cookie{Name: authVerifierCookieName, Value: "verifier"})
+			if test.stateCookie != "" {
+				req.AddCookie(&http.Cookie{Name: authStateCookieName, Value: test.stateCookie})
+			}
+			rec := httptest.NewRecorder()
+
+			if err := h.AuthCallback(echo.New().NewContext(req, rec)); err != nil {
+				t.Fatal(err)
+			}
+
+			location, err := url.Parse(rec.Header().Get(echo.HeaderLocation))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if authError := location.Query().Get("auth_error"); authError != test.wantAuthError {
+				t.Errorf("got auth_error %q, want %q", authError, test.wantAuthError)
+			}
+		})
+	}
+}
@@ -1,11 +1,17 @@
 package handlers
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"log/slog"
 	"net/http"
 	"os"
 	"strconv"
+	"time"
 	"world-sounds/models"
 	"world-sounds/services"
 
@@ -25,26 +31,14 @@ func (h *Handler) BidsCreate(c echo.Context) error {
 		return newEchoHTTPError(http.StatusBadRequest, "Credits must be an integer", err)
 	}
 
-	{
-		// Check if user has enough credits before handling the upload. This value will again be enforced when creating the bid
-		var userCredits int64
-		err := models.GetTx(h.DB, authToken)(c.Request().Context(), func(ctx context.Context, tx *edgedb.Tx) error {
-			user, err := models.UserFetch(ctx, tx)
-			if err != nil {
-				return err
-			}
-
-			userCredits = user.Credits
-
-			return nil
-		})
-		if err != nil {
-			return err
-		}
+	// Check if user has enough credits before handling the upload. This value will again be enforced when creating the bid
+	err = h.checkUserCredits(c.Request().Context(), authToken, creditsData)
+	if err != nil {
+		return err
+	}
 
-		if creditsData > userCredits {
-			return newEchoHTTPError(http.StatusBadRequest, "Credits must be less than or equal to user credits", nil)
-		}
+	if draftIDFormValue := c.FormValue("draft_id"); draftIDFormValue != "" {
+		return h.bidsCreateFromDraft(c, authToken, creditsData, draftIDFormValue)
 	}
 
 	uploadedAudio, err := c.FormFile("audio")
@@ -57,44 +51,59 @@ func (h *Handler) BidsCreate(c echo.Context) error {
 	}
 	defer src.Close()
 
-	filePath, durationSeconds, err := services.ProcessAudio(c.Request().Context(), src)
+	bidID, err := h.createBid(c.Request().Context(), authToken, creditsData, src)
 	if err != nil {
-		return fmt.Errorf("failed to process audio: %w", err)
+		return err
 	}
-	defer os.Remove(filePath)
 
-	if durationSeconds == 0 {
-		return newEchoHTTPError(http.StatusBadRequest, "Duration must not be zero", nil)
+	return c.JSON(http.StatusCreated, map[string]any{"id": bidID})
+}
+
+// Places a bid with the audio a draft already processed and stored, so the bid airs exactly what was previewed
+func (h *Handler) bidsCreateFromDraft(c echo.Context, authToken *models.AuthToken, credits int64, draftID string) error {
+	ctx := c.Request().Context()
+
+	draft, err := h.fetchDraft(ctx, authToken, draftID)
+	if err != nil {
+		return err
 	}
 
-	if creditsData < durationSeconds {
+	if credits < draft.AudioDurationSeconds {
 		return newEchoHTTPError(http.StatusBadRequest, "Credits must be greater than or equal to duration", nil)
 	}
 
-	fileHash, err := SHA256File(filePath)
+	// Checked again as similar audio may have aired or been queued since the draft was created
+	err = h.checkRepeatPlays(ctx, draft.Fingerprint)
 	if err != nil {
-		return fmt.Errorf("failed to hash audio file: %w", err)
+		return err
 	}
 
-	fileLocation, err := h.S3.UploadMP3(c.Request().Context(), filePath, fileHash+".mp3")
-	if err != nil {
-		return fmt.Errorf("failed to upload MP3: %w", err)
+	audio := &storedAudio{
+		Key:             draft.AudioKey,
+		DurationSeconds: draft.AudioDurationSeconds,
+		Fingerprint:     draft.Fingerprint,
+		WaveformKey:     draft.WaveformKey,
+		Renditions:      draft.Renditions,
 	}
 
+	// The draft is deleted along with placing the bid, so it can't be bid twice
 	var bidID string
-	err = models.GetTx(h.DB, authToken)(c.Request().Context(), func(ctx context.Context, tx *edgedb.Tx) error {
-		err = models.UserDecrementCredits(ctx, tx, creditsData)
+	err = models.GetTx(h.DB, authToken)(ctx, func(ctx context.Context, tx *edgedb.Tx) error {
+		err := models.DraftDelete(ctx, tx, draft.ID)
 		if err != nil {
 			return err
 		}
 
-		bidID, err = models.BidCreate(ctx, tx, fileLocation, durationSeconds, creditsData)
+		bidID, err = h.insertBid(ctx, tx, credits, audio)
 		if err != nil {
 			return err
 		}
 
 		return nil
 	})
+	if errors.Is(err, models.ErrDraftNotFound) {
+		return newEchoHTTPError(http.StatusNotFound, "draft does not exist or has expired", err)
+	}
 	if err != nil {
 		return err
 	}
@@ -102,6 +111,285 @@ func (h *Handler) BidsCreate(c echo.Context) error {
 	return c.JSON(http.StatusCreated, map[string]any{"id": bidID})
 }
 
+func (h *Handler) checkUserCredits(ctx context.Context, authToken *models.AuthToken, credits int64) error {
+	var userCredits int64
+	err := models.GetTx(h.DB, authToken)(ctx, func(ctx context.Context, tx *edgedb.Tx) error {
+		user, err := models.UserFetch(ctx, tx)
+		if err != nil {
+			return err
+		}
+
+		userCredits = user.Credits
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if credits > userCredits {
+		return newEchoHTTPError(http.StatusBadRequest, "Credits must be less than or equal to user credits", nil)
+	}
+
+	return nil
+}
+
+func (h *Handler) createBid(ctx context.Context, authToken *models.AuthToken, credits int64, src io.Reader) (string, error) {
+	processedAudio, err := h.processUpload(ctx, src)
+	if err != nil {
+		return "", err
+	}
+	defer processedAudio.Remove()
+
+	if credits < processedAudio.DurationSeconds {
+		return "", newEchoHTTPError(http.StatusBadRequest, "Credits must be greater than or equal to duration", nil)
+	}
+
+	err = h.checkRepeatPlays(ctx, processedAudio.Fingerprint)
+	if err != nil {
+		return "", err
+	}
+
+	audio, err := h.storeAudio(ctx, processedAudio)
+	if err != nil {
+		return "", err
+	}
+
+	var bidID string
+	err = models.GetTx(h.DB, authToken)(ctx, func(ctx context.Context, tx *edgedb.Tx) error {
+		bidID, err = h.insertBid(ctx, tx, credits, audio)
+		if err != nil {
+			return err
+		}
+
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return bidID, nil
+}
+
+func (h *Handler) insertBid(ctx context.Context, tx *edgedb.Tx, credits int64, audio *storedAudio) (string, error) {
+	err := models.UserDecrementCredits(ctx, tx, credits)
+	if err != nil {
+		return "", err
+	}
+
+	return models.BidCreate(ctx, tx, audio.Key, audio.DurationSeconds, credits, audio.Fingerprint, audio.WaveformKey, audio.Renditions, h.ReportThreshold)
+}
+
+// Checks the upload against the upload policy and transcodes it along with its renditions. The caller removes the
+// processed files
+func (h *Handler) processUpload(ctx context.Context, src io.Reader) (*services.ProcessedAudio, error) {
+	inputPath, err := h.checkUploadPolicy(ctx, src)
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(inputPath)
+
+	input, err := os.Open(inputPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open upload: %w", err)
+	}
+	defer input.Close()
+
+	// The stream ID isn't known until the bid airs, and the files are stored by content hash so the same upload bid twice
+	// shares them, so the platform is the only tag written into the files
+	audio, err := h.Audio.ProcessAudio(ctx, input, h.AudioRenditions, map[string]string{"publisher": PlatformName})
+	if err != nil {
+		return nil, fmt.Errorf("failed to process audio: %w", err)
+	}
+
+	if audio.DurationSeconds == 0 {
+		audio.Remove()
+		return nil, newEchoHTTPError(http.StatusBadRequest, "Duration must not be zero", nil)
+	}
+
+	return audio, nil
+}
+
+// Audio as bids and drafts reference it once its objects are stored
+type storedAudio struct {
+	Key             string
+	DurationSeconds int64
+	Fingerprint     []int32
+	WaveformKey     string
+	Renditions      []models.AudioRendition
+}
+
+// Stores the processed audio under the hash of its primary file. Objects are only collected once no bid, draft or
+// stream references them anymore
+func (h *Handler) storeAudio(ctx context.Context, audio *services.ProcessedAudio) (*storedAudio, error) {
+	fileHash, err := SHA256File(audio.FilePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to hash audio file: %w", err)
+	}
+
+	// Audio stays private until it airs, only the waveform is public
+	fileKey := fileHash + "." + audio.Extension
+	err = services.PutFile(ctx, h.Blobs, services.BucketPrivateAudio, fileKey, audio.FilePath, audio.ContentType)
+	if err != nil {
+		return nil, fmt.Errorf("failed to upload audio: %w", err)
+	}
+
+	renditions := []models.AudioRendition{}
+	for _, rendition := range audio.Renditions {
+		renditionKey := fmt.Sprintf("%s-%s.%s", fileHash, rendition.Profile.Name(), rendition.Profile.Extension)
+		err = services.PutFile(ctx, h.Blobs, services.BucketPrivateAudio, renditionKey, rendition.FilePath, rendition.Profile.ContentType)
+		if err != nil {
+			return nil, fmt.Errorf("failed to upload %s rendition: %w", rendition.Profile.Name(), err)
+		}
+
+		renditions = append(renditions, models.AudioRendition{
+			Codec:       rendition.Profile.Codec,
+			BitrateKbps: rendition.Profile.BitrateKbps,
+			ContentType: rendition.Profile.ContentType,
+			Key:         renditionKey,
+		})
+	}
+
+	waveformBytes, err := json.Marshal(audio.Waveform)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal waveform: %w", err)
+	}
+
+	waveformKey := fileHash + ".json"
+	err = h.Blobs.Put(ctx, services.BucketAudio, waveformKey, bytes.NewReader(waveformBytes), int64(len(waveformBytes)), "application/json")
+	if err != nil {
+		return nil, fmt.Errorf("failed to upload waveform: %w", err)
+	}
+
+	return &storedAudio{
+		Key:             fileKey,
+		DurationSeconds: audio.DurationSeconds,
+		Fingerprint:     audio.Fingerprint,
+		WaveformKey:     waveformKey,
+		Renditions:      renditions,
+	}, nil
+}
+
+// Buffers the upload into a temporary file and checks it against the upload policy before it is transcoded
+func (h *Handler) checkUploadPolicy(ctx context.Context, src io.Reader) (string, error) {
+	inputPath, inputSize, err := services.BufferUpload(src, h.UploadPolicy.MaxSizeBytes)
+	if errors.Is(err, services.ErrUploadTooLarge) {
+		return "", newEchoHTTPError(http.StatusRequestEntityTooLarge, map[string]any{
+			"message":    "Upload violates the upload policy",
+			"violations": []services.UploadPolicyViolation{{Code: "max_size", Message: fmt.Sprintf("size must be less than or equal to %d bytes", h.UploadPolicy.MaxSizeBytes)}},
+		}, err)
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to buffer upload: %w", err)
+	}
+
+	probe, err := h.Audio.ProbeAudio(ctx, inputPath)
+	if err != nil {
+		os.Remove(inputPath)
+		return "", newEchoHTTPError(http.StatusUnprocessableEntity, "Upload is not a readable audio file", err)
+	}
+
+	violations := h.UploadPolicy.Check(probe, inputSize)
+	if len(violations) > 0 {
+		os.Remove(inputPath)
+		return "", newEchoHTTPError(http.StatusUnprocessableEntity, map[string]any{
+			"message":    "Upload violates the upload policy",
+			"violations": violations,
+		}, nil)
+	}
+
+	return inputPath, nil
+}
+
+// Queued bids count as plays too, since they air later. They are the newest plays, so as long as aired streams alone are
+// enough to leave the window the audio becomes eligible once they do, otherwise it depends on when the bids air
+func (h *Handler) checkRepeatPlays(ctx context.Context, fingerprint []int32) error {
+	var streams []models.StreamFingerprintsFetchResult
+	var bids []models.BidFingerprintsFetchResult
+	err := models.GetTx(h.DB, nil)(ctx, func(ctx context.Context, tx *edgedb.Tx) error {
+		var err error
+		streams, err = models.StreamFingerprintsFetch(ctx, tx, time.Now().Add(-h.RepeatPlayWindow))
+		if err != nil {
+			return err
+		}
+
+		bids, err = models.BidFingerprintsFetch(ctx, tx)
+		if err != nil {
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to fetch fingerprints: %w", err)
+	}
+
+	matchingStreams := matchingStreams(fingerprint, streams)
+
+	matchingBids := 0
+	for _, bid := range bids {
+		if services.FingerprintsMatch(fingerprint, bid.Fingerprint) {
+			matchingBids++
+		}
+	}
+
+	excess := len(matchingStreams) + matchingBids - h.RepeatPlayLimit
+	if excess < 0 {
+		return nil
+	}
+
+	if excess >= len(matchingStreams) {
+		return newEchoHTTPError(http.StatusConflict, fmt.Sprintf("Similar audio was already streamed %d times in the last %v and is queued %d times, it will be eligible again once the queued bids air and leave that window", len(matchingStreams), h.RepeatPlayWindow, matchingBids), nil)
+	}
+
+	// Streams are ordered by creation, so the audio is eligible once enough of the oldest plays leave the window
+	eligibleAt := matchingStreams[excess].CreatedAt.Add(h.RepeatPlayWindow)
+	return newEchoHTTPError(http.StatusConflict, fmt.Sprintf("Similar audio was already streamed %d times in the last %v and is queued %d times, it will be eligible again at %s", len(matchingStreams), h.RepeatPlayWindow, matchingBids, eligibleAt.UTC().Format(time.RFC3339)), nil)
+}
+
+func matchingStreams(fingerprint []int32, streams []models.StreamFingerprintsFetchResult) []models.StreamFingerprintsFetchResult {
+	matchingStreams := []models.StreamFingerprintsFetchResult{}
+	for _, stream := range streams {
+		if services.FingerprintsMatch(fingerprint, stream.Fingerprint) {
+			matchingStreams = append(matchingStreams, stream)
+		}
+	}
+	return matchingStreams
+}
+
+// Dequeues the top bid that is still within the repeat play limit. Bids placed while similar audio was queued can reach
+// the limit by the time they air, those are removed and refunded
+func (h *Handler) BidsTopDequeueEligible(ctx context.Context, tx *edgedb.Tx) (*models.BidsTopDequeueReturn, error) {
+	streams, err := models.StreamFingerprintsFetch(ctx, tx, time.Now().Add(-h.RepeatPlayWindow))
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch stream fingerprints: %w", err)
+	}
+
+	for {
+		bid, err := models.BidsTopDequeue(ctx, tx)
+		if err != nil {
+			return nil, err
+		}
+
+		if bid == nil || len(matchingStreams(bid.Fingerprint, streams)) < h.RepeatPlayLimit {
+			return bid, nil
+		}
+
+		_, err = models.UserIncrementCredits(ctx, tx, bid.UserID, bid.Credits)
+		if err != nil {
+			return nil, err
+		}
+
+		err = models.AuditEventCreate(ctx, tx, models.AuditSource{}, "bid.delete", models.AuditTargetBid, edgedb.NewOptionalUUID(bid.ID), "Repeat play limit reached", bid, map[string]any{
+			"refunded_credits": bid.Credits,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		slog.Info("Removed bid over the repeat play limit", slog.String("bidID", bid.ID.String()))
+	}
+}
+
 func (h *Handler) BidsFetch(c echo.Context) error {
 	authToken, err := GetAuthToken(c)
 	if err != nil {
@@ -120,6 +408,14 @@ func (h *Handler) BidsFetch(c echo.Context) error {
 		return err
 	}
 
+	// Queued audio is only ever exposed to its owner
+	for i := range feed {
+		feed[i].AudioURI, err = h.signAudio(c.Request().Context(), feed[i].AudioKey, feed[i].Renditions, ownerAudioURLExpiry)
+		if err != nil {
+			return err
+		}
+	}
+
 	return c.JSON(http.StatusOK, feed)
 }
 
@@ -137,6 +433,11 @@ func (h *Handler) BidsTopFetch(c echo.Context) error {
 		return err
 	}
 
+	for i := range feed {
+		feed[i].WaveformURI = h.waveformURI(feed[i].WaveformKey)
+		feed[i].User.ImageURI = h.imageURIs(feed[i].User.ImageKeys)
+	}
+
 	return c.JSON(http.StatusOK, feed)
 }
 
@@ -156,8 +457,16 @@ func (h *Handler) BidsDelete(c echo.Context) error {
 	}
 
 	err = models.GetTx(h.DB, authToken)(c.Request().Context(), func(ctx context.Context, tx *edgedb.Tx) error {
-		return models.BidDelete(ctx, tx, data.BidID)
+		bid, err := models.BidDelete(ctx, tx, data.BidID)
+		if err != nil {
+			return err
+		}
+
+		return models.AuditEventCreate(ctx, tx, auditSource(c), "bid.delete", models.AuditTargetBid, edgedb.NewOptionalUUID(bid.ID), "", bid, nil)
 	})
+	if errors.Is(err, models.ErrBidNotFound) {
+		return newEchoHTTPError(http.StatusNotFound, "bid does not exist", err)
+	}
 	if err != nil {
 		return err
 	}
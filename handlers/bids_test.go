@@ -0,0 +1,279 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+	"world-sounds/models"
+	"world-sounds/services"
+)
+
+func TestBidsCreateRejectsInvalidRequests(t *testing.T) {
+	h := testHandler(t)
+	authToken := models.AuthToken{ClientToken: "client-token"}
+
+	req := testMultipartRequest(t, http.MethodPost, "/api/v1/bids", map[string]string{"credits": "10"}, nil)
+	if code, _ := testServe(t, h.BidsCreate, req, nil); code != http.StatusUnauthorized {
+		t.Errorf("without auth token got %d, want %d", code, http.StatusUnauthorized)
+	}
+
+	req = testMultipartRequest(t, http.MethodPost, "/api/v1/bids", map[string]string{"credits": "ten"}, nil)
+	if code, _ := testServe(t, h.BidsCreate, req, &authToken); code != http.StatusBadRequest {
+		t.Errorf("with non-integer credits got %d, want %d", code, http.StatusBadRequest)
+	}
+}
+
+// Uploads failing the policy or the credit check are rejected before the database and store are touched
+func TestCreateBidRejectsUploads(t *testing.T) {
+	h := testHandler(t)
+	authToken := &models.AuthToken{ClientToken: "client-token"}
+
+	tests := []struct {
+		name     string
+		credits  int64
+		upload   []byte
+		wantCode int
+	}{
+		{"not audio", 10, []byte("definitely not audio"), http.StatusUnprocessableEntity},
+		{"too short", 10, testWAV(0, 0), http.StatusUnprocessableEntity},
+		{"credits below duration", 2, testWAV(5, 0), http.StatusBadRequest},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := h.createBid(context.Background(), authToken, test.credits, bytes.NewReader(test.upload))
+			if code := httpErrorCode(t, err); code != test.wantCode {
+				t.Errorf("got %d, want %d", code, test.wantCode)
+			}
+		})
+	}
+
+	err := h.Blobs.List(context.Background(), services.BucketPrivateAudio, func(key string, info *services.BlobInfo) error {
+		t.Errorf("rejected upload stored %s", key)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBidsCreate(t *testing.T) {
+	h := testHandler(t)
+	testDB(t, h)
+	authToken := testUser(t, h, 100)
+
+	req := testMultipartRequest(t, http.MethodPost, "/api/v1/bids", map[string]string{"credits": "10"}, map[string][]byte{"audio": testWAV(5, 1)})
+	code, rec := testServe(t, h.BidsCreate, req, &authToken)
+	if code != http.StatusCreated {
+		t.Fatalf("got %d, want %d: %s", code, http.StatusCreated, rec.Body)
+	}
+
+	var response struct {
+		ID string `json:"id"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+
+	var bid struct {
+		AudioKey             string `edgedb:"audio_key"`
+		WaveformKey          string `edgedb:"waveform_key"`
+		AudioDurationSeconds int64  `edgedb:"audio_duration_seconds"`
+		UserCredits          int64  `edgedb:"user_credits"`
+	}
+	err := h.DB.QuerySingle(
+		context.Background(),
+		`SELECT Bid {
+			audio_key,
+			waveform_key,
+			audio_duration_seconds,
+			user_credits := .user.credits
+		} FILTER .id = <uuid><str>$id`,
+		&bid,
+		map[string]interface{}{"id": response.ID},
+	)
+	if err != nil {
+		t.Fatalf("failed to fetch bid: %v", err)
+	}
+
+	if bid.AudioDurationSeconds != 5 {
+		t.Errorf("duration is %d seconds, want 5", bid.AudioDurationSeconds)
+	}
+	if bid.UserCredits != 90 {
+		t.Errorf("user has %d credits left, want 90", bid.UserCredits)
+	}
+	if !strings.HasSuffix(bid.AudioKey, ".wav") {
+		t.Errorf("audio key %s isn't a WAV file", bid.AudioKey)
+	}
+
+	if _, err := h.Blobs.Stat(context.Background(), services.BucketPrivateAudio, bid.AudioKey); err != nil {
+		t.Errorf("audio isn't stored in the private bucket: %v", err)
+	}
+	if _, err := h.Blobs.Stat(context.Background(), services.BucketAudio, bid.WaveformKey); err != nil {
+		t.Errorf("waveform isn't stored in the public bucket: %v", err)
+	}
+
+	// The owner gets a signed URL the filesystem store serves
+	code, rec = testServe(t, h.BidsFetch, httptest.NewRequest(http.MethodGet, "/api/v1/bids", nil), &authToken)
+	if code != http.StatusOK {
+		t.Fatalf("fetching bids got %d, want %d: %s", code, http.StatusOK, rec.Body)
+	}
+
+	var feed []struct {
+		AudioURI string `json:"audio_uri"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &feed); err != nil {
+		t.Fatal(err)
+	}
+	if len(feed) != 1 {
+		t.Fatalf("got %d bids, want 1", len(feed))
+	}
+
+	rec = testFetchBlob(t, h, feed[0].AudioURI)
+	if rec.Code != http.StatusOK || !bytes.HasPrefix(rec.Body.Bytes(), []byte("RIFF")) {
+		t.Errorf("audio URL got %d with %d bytes, want a WAV file", rec.Code, rec.Body.Len())
+	}
+}
+
+func TestBidsCreateRejectsMissingCredits(t *testing.T) {
+	h := testHandler(t)
+	testDB(t, h)
+	authToken := testUser(t, h, 5)
+
+	req := testMultipartRequest(t, http.MethodPost, "/api/v1/bids", map[string]string{"credits": "10"}, map[string][]byte{"audio": testWAV(5, 2)})
+	if code, _ := testServe(t, h.BidsCreate, req, &authToken); code != http.StatusBadRequest {
+		t.Errorf("got %d, want %d", code, http.StatusBadRequest)
+	}
+}
+
+func TestBidsCreateCountsQueuedRepeats(t *testing.T) {
+	h := testHandler(t)
+	testDB(t, h)
+	authToken := testUser(t, h, 100)
+
+	for i := 0; i <= h.RepeatPlayLimit; i++ {
+		wantCode := http.StatusCreated
+		if i == h.RepeatPlayLimit {
+			wantCode = http.StatusConflict
+		}
+
+		req := testMultipartRequest(t, http.MethodPost, "/api/v1/bids", map[string]string{"credits": "10"}, map[string][]byte{"audio": testWAV(5, 3)})
+		if code, rec := testServe(t, h.BidsCreate, req, &authToken); code != wantCode {
+			t.Fatalf("bid %d got %d, want %d: %s", i+1, code, wantCode, rec.Body)
+		}
+	}
+}
+
+func TestBidsCreateHoldsBidsOfEscalatedBidders(t *testing.T) {
+	h := testHandler(t)
+	testDB(t, h)
+	h.ReportThreshold = 1
+	authToken := testUser(t, h, 100)
+	reporter := testUser(t, h, 0)
+
+	err := h.DB.Execute(
+		context.Background(),
+		`WITH
+			stream := (INSERT Stream {
+				audio_key := 'escalated.wav',
+				audio_duration_seconds := 5,
+				credits := 5,
+				user := (SELECT User FILTER .id = <uuid>$user_id)
+			})
+		INSERT Report {
+			category := ReportCategory.spam,
+			stream := stream,
+			reporter := (SELECT User FILTER .id = <uuid>$reporter_id)
+		}`,
+		map[string]interface{}{"user_id": authToken.UserID, "reporter_id": reporter.UserID},
+	)
+	if err != nil {
+		t.Fatalf("failed to escalate stream: %v", err)
+	}
+
+	req := testMultipartRequest(t, http.MethodPost, "/api/v1/bids", map[string]string{"credits": "10"}, map[string][]byte{"audio": testWAV(5, 4)})
+	code, rec := testServe(t, h.BidsCreate, req, &authToken)
+	if code != http.StatusCreated {
+		t.Fatalf("got %d, want %d: %s", code, http.StatusCreated, rec.Body)
+	}
+
+	var response struct {
+		ID string `json:"id"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+
+	var held bool
+	err = h.DB.QuerySingle(context.Background(), `SELECT EXISTS (SELECT Bid FILTER .id = <uuid><str>$id).held_at`, &held, map[string]interface{}{"id": response.ID})
+	if err != nil {
+		t.Fatalf("failed to fetch bid: %v", err)
+	}
+	if !held {
+		t.Error("bid of an escalated bidder isn't held")
+	}
+}
+
+func TestBidsCreateFromDraft(t *testing.T) {
+	h := testHandler(t)
+	testDB(t, h)
+	h.DraftTTL = time.Hour
+	authToken := testUser(t, h, 100)
+
+	req := testMultipartRequest(t, http.MethodPost, "/api/v1/me/drafts", nil, map[string][]byte{"audio": testWAV(5, 5)})
+	code, rec := testServe(t, h.DraftsCreate, req, &authToken)
+	if code != http.StatusCreated {
+		t.Fatalf("creating draft got %d, want %d: %s", code, http.StatusCreated, rec.Body)
+	}
+
+	var draft DraftResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &draft); err != nil {
+		t.Fatal(err)
+	}
+
+	// The previewed audio must not change once the bid is placed, even if the source would transcode differently
+	previewed := testFetchBlob(t, h, draft.PreviewURL)
+	if previewed.Code != http.StatusOK {
+		t.Fatalf("preview URL got %d, want %d", previewed.Code, http.StatusOK)
+	}
+	h.Audio = nil
+
+	req = testMultipartRequest(t, http.MethodPost, "/api/v1/bids", map[string]string{"credits": "10", "draft_id": draft.ID}, nil)
+	code, rec = testServe(t, h.BidsCreate, req, &authToken)
+	if code != http.StatusCreated {
+		t.Fatalf("placing bid got %d, want %d: %s", code, http.StatusCreated, rec.Body)
+	}
+
+	var bid struct {
+		AudioKey string `edgedb:"audio_key"`
+	}
+	err := h.DB.QuerySingle(context.Background(), `SELECT Bid { audio_key } FILTER .user.id = <uuid>$user_id LIMIT 1`, &bid, map[string]interface{}{"user_id": authToken.UserID})
+	if err != nil {
+		t.Fatalf("failed to fetch bid: %v", err)
+	}
+
+	audio, err := h.Blobs.Get(context.Background(), services.BucketPrivateAudio, bid.AudioKey)
+	if err != nil {
+		t.Fatalf("failed to get bid audio: %v", err)
+	}
+	defer audio.Close()
+	audioBytes, err := io.ReadAll(audio)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(audioBytes, previewed.Body.Bytes()) {
+		t.Error("bid audio differs from the previewed audio")
+	}
+
+	// The draft is used up by the bid
+	req = testMultipartRequest(t, http.MethodPost, "/api/v1/bids", map[string]string{"credits": "10", "draft_id": draft.ID}, nil)
+	if code, _ := testServe(t, h.BidsCreate, req, &authToken); code != http.StatusNotFound {
+		t.Errorf("placing a second bid from the draft got %d, want %d", code, http.StatusNotFound)
+	}
+}
@@ -0,0 +1,52 @@
+package handlers
+
+import (
+	"net/http"
+	"net/url"
+	"slices"
+	"world-sounds/models"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Rejects state changing requests authenticated by the auth token cookie unless they come from this origin or one of
+// the allowed origins. Browsers attach the cookie to cross site form posts too, but they can't forge the Origin header.
+// Access tokens aren't sent automatically by browsers, so they don't need the check
+func (h *Handler) CheckOrigin(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		switch c.Request().Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			return next(c)
+		}
+
+		authToken, ok := c.Get("authToken").(models.AuthToken)
+		if !ok || authToken.ClientToken == "" {
+			return next(c)
+		}
+
+		origin := c.Request().Header.Get(echo.HeaderOrigin)
+		if origin == "" {
+			// Older browsers only send the referer
+			origin = c.Request().Referer()
+		}
+
+		if !h.isAllowedOrigin(c, origin) {
+			return newEchoHTTPError(http.StatusForbidden, "Forbidden: cross origin request", nil)
+		}
+
+		return next(c)
+	}
+}
+
+func (h *Handler) isAllowedOrigin(c echo.Context, origin string) bool {
+	originURL, err := url.Parse(origin)
+	if err != nil || originURL.Host == "" {
+		return false
+	}
+
+	if originURL.Host == c.Request().Host {
+		return true
+	}
+
+	return slices.Contains(h.AllowedOrigins, originURL.Scheme+"://"+originURL.Host)
+}
@@ -0,0 +1,71 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"world-sounds/models"
+
+	"github.com/labstack/echo/v4"
+)
+
+func TestCheckOrigin(t *testing.T) {
+	h := &Handler{AllowedOrigins: []string{"https://app.example.com"}}
+
+	cookieAuth := models.AuthToken{ClientToken: "client-token"}
+	bearerAuth := models.AuthToken{Scopes: []string{ScopeBidsWrite}}
+
+	tests := []struct {
+		name      string
+		method    string
+		authToken models.AuthToken
+		origin    string
+		referer   string
+		wantCode  int
+	}{
+		{"foreign origin", http.MethodPost, cookieAuth, "https://evil.example.net", "", http.StatusForbidden},
+		{"foreign origin on patch", http.MethodPatch, cookieAuth, "https://evil.example.net", "", http.StatusForbidden},
+		{"foreign origin on delete", http.MethodDelete, cookieAuth, "https://evil.example.net", "", http.StatusForbidden},
+		{"foreign referer without origin", http.MethodPost, cookieAuth, "", "https://evil.example.net/page", http.StatusForbidden},
+		{"neither origin nor referer", http.MethodPost, cookieAuth, "", "", http.StatusForbidden},
+		{"null origin", http.MethodPost, cookieAuth, "null", "", http.StatusForbidden},
+		{"same host origin", http.MethodPost, cookieAuth, "https://api.example.com", "", http.StatusOK},
+		{"same host referer without origin", http.MethodDelete, cookieAuth, "", "https://api.example.com/settings", http.StatusOK},
+		{"allowlisted origin", http.MethodPatch, cookieAuth, "https://app.example.com", "", http.StatusOK},
+		{"allowlisted origin with another scheme", http.MethodPost, cookieAuth, "http://app.example.com", "", http.StatusForbidden},
+		{"bearer token with foreign origin", http.MethodPost, bearerAuth, "https://evil.example.net", "", http.StatusOK},
+		{"safe method with foreign origin", http.MethodGet, cookieAuth, "https://evil.example.net", "", http.StatusOK},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest(test.method, "https://api.example.com/api/v1/bids", nil)
+			if test.origin != "" {
+				req.Header.Set(echo.HeaderOrigin, test.origin)
+			}
+			if test.referer != "" {
+				req.Header.Set("Referer", test.referer)
+			}
+			rec := httptest.NewRecorder()
+			c := echo.New().NewContext(req, rec)
+			c.Set("authToken", test.authToken)
+
+			err := h.CheckOrigin(func(c echo.Context) error {
+				return c.NoContent(http.StatusOK)
+			})(c)
+
+			code := rec.Code
+			var httpError *echo.HTTPError
+			if errors.As(err, &httpError) {
+				code = httpError.Code
+			} else if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if code != test.wantCode {
+				t.Errorf("got status %d, want %d", code, test.wantCode)
+			}
+		})
+	}
+}
@@ -76,12 +76,25 @@ func (h *Handler) DepositsWebhook(c echo.Context) error {
 			return err
 		}
 
-		err = models.UserIncrementCredits(ctx, tx, userID, depositedCredits)
+		credits, err := models.UserIncrementCredits(ctx, tx, userID, depositedCredits)
 		if err != nil {
 			return err
 		}
 
-		return nil
+		depositUUID, err := edgedb.ParseUUID(depositID)
+		if err != nil {
+			return err
+		}
+
+		return models.AuditEventCreate(ctx, tx, auditSource(c), "deposit.create", models.AuditTargetDeposit, edgedb.NewOptionalUUID(depositUUID), "", map[string]any{
+			"user_id": userID,
+			"credits": credits - depositedCredits,
+		}, map[string]any{
+			"user_id":               userID,
+			"credits":               credits,
+			"deposited_credits":     depositedCredits,
+			"remote_transaction_id": data.Data.TransactionID,
+		})
 	})
 	if err != nil {
 		return err
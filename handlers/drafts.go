@@ -0,0 +1,194 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+	"world-sounds/models"
+	"world-sounds/services"
+
+	"github.com/edgedb/edgedb-go"
+	"github.com/labstack/echo/v4"
+)
+
+const draftPreviewURLExpiry = 15 * time.Minute
+
+type DraftResponse struct {
+	ID                   string    `json:"id"`
+	PreviewURL           string    `json:"preview_url"`
+	AudioDurationSeconds int64     `json:"audio_duration_seconds"`
+	MinCredits           int64     `json:"min_credits"`
+	ExpiresAt            time.Time `json:"expires_at"`
+}
+
+func (h *Handler) DraftsCreate(c echo.Context) error {
+	authToken, err := GetAuthToken(c)
+	if err != nil {
+		return err
+	}
+
+	uploadedAudio, err := c.FormFile("audio")
+	if err != nil {
+		return newEchoHTTPError(http.StatusBadRequest, "audio not provided", err)
+	}
+	src, err := uploadedAudio.Open()
+	if err != nil {
+		return fmt.Errorf("failed to open audio file: %w", err)
+	}
+	defer src.Close()
+
+	ctx := c.Request().Context()
+
+	// Processed just like a bid, so placing the bid reuses the exact audio that was previewed
+	processedAudio, err := h.processUpload(ctx, src)
+	if err != nil {
+		return err
+	}
+	defer processedAudio.Remove()
+
+	err = h.checkRepeatPlays(ctx, processedAudio.Fingerprint)
+	if err != nil {
+		return err
+	}
+
+	audio, err := h.storeAudio(ctx, processedAudio)
+	if err != nil {
+		return err
+	}
+
+	expiresAt := time.Now().Add(h.DraftTTL)
+
+	var draftID string
+	err = models.GetTx(h.DB, authToken)(ctx, func(ctx context.Context, tx *edgedb.Tx) error {
+		draftID, err = models.DraftCreate(ctx, tx, audio.Key, audio.DurationSeconds, audio.Fingerprint, audio.WaveformKey, audio.Renditions, expiresAt)
+		if err != nil {
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create draft: %w", err)
+	}
+
+	previewURL, err := h.Blobs.SignedURL(ctx, services.BucketPrivateAudio, audio.Key, min(draftPreviewURLExpiry, h.DraftTTL))
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusCreated, DraftResponse{
+		ID:                   draftID,
+		PreviewURL:           previewURL,
+		AudioDurationSeconds: audio.DurationSeconds,
+		MinCredits:           audio.DurationSeconds,
+		ExpiresAt:            expiresAt,
+	})
+}
+
+func (h *Handler) DraftsFetch(c echo.Context) error {
+	authToken, err := GetAuthToken(c)
+	if err != nil {
+		return err
+	}
+
+	draft, err := h.fetchDraft(c.Request().Context(), authToken, c.Param("id"))
+	if err != nil {
+		return err
+	}
+
+	previewURL, err := h.Blobs.SignedURL(c.Request().Context(), services.BucketPrivateAudio, draft.AudioKey, min(draftPreviewURLExpiry, time.Until(draft.ExpiresAt)))
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, DraftResponse{
+		ID:                   draft.ID.String(),
+		PreviewURL:           previewURL,
+		AudioDurationSeconds: draft.AudioDurationSeconds,
+		MinCredits:           draft.AudioDurationSeconds,
+		ExpiresAt:            draft.ExpiresAt,
+	})
+}
+
+func (h *Handler) DraftsDelete(c echo.Context) error {
+	authToken, err := GetAuthToken(c)
+	if err != nil {
+		return err
+	}
+
+	draft, err := h.fetchDraft(c.Request().Context(), authToken, c.Param("id"))
+	if err != nil {
+		return err
+	}
+
+	err = h.removeDraft(c.Request().Context(), authToken, draft)
+	if err != nil {
+		return err
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+// Deletes expired drafts, returning how many were removed. Their objects are left to the object GC, as bids of the
+// same audio share them
+func (h *Handler) DraftsCleanup(ctx context.Context) (int, error) {
+	removed := 0
+	for {
+		var deleted int64
+		err := models.GetTx(h.DB, nil)(ctx, func(ctx context.Context, tx *edgedb.Tx) error {
+			var err error
+			deleted, err = models.DraftsExpiredDelete(ctx, tx, 100)
+			if err != nil {
+				return err
+			}
+			return nil
+		})
+		if err != nil {
+			return removed, fmt.Errorf("failed to delete expired drafts: %w", err)
+		}
+
+		if deleted == 0 {
+			return removed, nil
+		}
+		removed += int(deleted)
+	}
+}
+
+func (h *Handler) fetchDraft(ctx context.Context, authToken *models.AuthToken, id string) (*models.DraftFetchResult, error) {
+	draftID, err := edgedb.ParseUUID(id)
+	if err != nil {
+		return nil, newEchoHTTPError(http.StatusBadRequest, fmt.Sprintf("invalid draft id: %s", id), err)
+	}
+
+	var draft *models.DraftFetchResult
+	err = models.GetTx(h.DB, authToken)(ctx, func(ctx context.Context, tx *edgedb.Tx) error {
+		draft, err = models.DraftFetch(ctx, tx, draftID)
+		if err != nil {
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch draft: %w", err)
+	}
+	if draft == nil {
+		return nil, newEchoHTTPError(http.StatusNotFound, "draft does not exist or has expired", nil)
+	}
+
+	return draft, nil
+}
+
+func (h *Handler) removeDraft(ctx context.Context, authToken *models.AuthToken, draft *models.DraftFetchResult) error {
+	err := models.GetTx(h.DB, authToken)(ctx, func(ctx context.Context, tx *edgedb.Tx) error {
+		return models.DraftDelete(ctx, tx, draft.ID)
+	})
+	if errors.Is(err, models.ErrDraftNotFound) {
+		return newEchoHTTPError(http.StatusNotFound, "draft does not exist or has expired", err)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to delete draft: %w", err)
+	}
+
+	return nil
+}
@@ -1,6 +1,8 @@
 package handlers
 
 import (
+	"context"
+	"crypto/rand"
 	"crypto/sha256"
 	"encoding/hex"
 	"errors"
@@ -8,7 +10,11 @@ import (
 	"io"
 	"log/slog"
 	"net/http"
+	"net/url"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 	"world-sounds/models"
 	"world-sounds/services"
 
@@ -16,13 +22,43 @@ import (
 	"github.com/labstack/echo/v4"
 )
 
+const PlatformName = "World Sounds"
+
 type (
+	AudioProcessor interface {
+		ProbeAudio(ctx context.Context, filePath string) (*services.AudioProbe, error)
+		ProcessAudio(ctx context.Context, reader io.Reader, renditionProfiles []services.RenditionProfile, tags map[string]string) (*services.ProcessedAudio, error)
+	}
+
+	ImageProcessor interface {
+		ProcessImage(ctx context.Context, reader io.Reader, sizes []int) (*services.ProcessedImage, error)
+	}
+
 	Handler struct {
 		DB                 *edgedb.Client
-		S3                 *services.S3Service
+		Blobs              services.BlobStore
+		URLs               services.URLBuilder
 		Paddle             *services.PaddleService
+		Audio              AudioProcessor
+		Image              ImageProcessor
 		AuthPublicBaseURL  string
 		AuthPrivateBaseURL string
+		AuthHTTPClient     *http.Client
+		RepeatPlayLimit    int
+		RepeatPlayWindow   time.Duration
+		AudioRenditions    []services.RenditionProfile
+		UploadPolicy       services.UploadPolicy
+		UsernamePolicy     services.UsernamePolicy
+		UsernameCooldown   time.Duration
+		DraftTTL           time.Duration
+		UploadTTL          time.Duration
+		SessionMaxAge      time.Duration
+		ObjectGCGrace      time.Duration
+		ObjectGCDryRun     bool
+		StreamRetention    time.Duration
+		AllowedOrigins     []string
+		SchedulerWake      chan struct{}
+		ReportThreshold    int64
 	}
 )
 
@@ -32,9 +68,14 @@ func NewHandler() (*Handler, error) {
 		return nil, fmt.Errorf("failed to create DB service: %w", err)
 	}
 
-	s3Service, err := services.NewS3Service()
+	blobStore, err := services.NewBlobStore()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create blob store: %w", err)
+	}
+
+	urlBuilder, err := services.NewCDNURLBuilder(blobStore)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create S3 service: %w", err)
+		return nil, fmt.Errorf("failed to create URL builder: %w", err)
 	}
 
 	paddleService, err := services.NewPaddleService()
@@ -42,6 +83,24 @@ func NewHandler() (*Handler, error) {
 		return nil, fmt.Errorf("failed to create Paddle service: %w", err)
 	}
 
+	var audioProcessor AudioProcessor
+	var imageProcessor ImageProcessor
+	switch mediaProcessor := os.Getenv("MEDIA_PROCESSOR"); mediaProcessor {
+	case "", "ffmpeg":
+		ffmpegSandbox, err := services.NewFFmpegSandbox()
+		if err != nil {
+			return nil, fmt.Errorf("failed to create FFmpeg sandbox: %w", err)
+		}
+		audioProcessor = ffmpegSandbox
+		imageProcessor = ffmpegSandbox
+	case "go":
+		goProcessor := services.NewGoProcessor()
+		audioProcessor = goProcessor
+		imageProcessor = goProcessor
+	default:
+		return nil, fmt.Errorf("MEDIA_PROCESSOR environment variable must be 'ffmpeg' or 'go': %s", mediaProcessor)
+	}
+
 	edgedbAuthPublicBaseURL, ok := os.LookupEnv("EDGEDB_AUTH_PUBLIC_BASE_URL")
 	if !ok {
 		return nil, errors.New("EDGEDB_AUTH_PUBLIC_BASE_URL environment variable not set")
@@ -52,12 +111,152 @@ func NewHandler() (*Handler, error) {
 		return nil, errors.New("EDGEDB_AUTH_PRIVATE_BASE_URL environment variable not set")
 	}
 
+	authHTTPClient, err := newAuthHTTPClient(os.Getenv("EDGEDB_AUTH_CA_FILE"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create auth HTTP client: %w", err)
+	}
+
+	repeatPlayLimit := 3
+	if repeatPlayLimitString := os.Getenv("REPEAT_PLAY_LIMIT"); repeatPlayLimitString != "" {
+		repeatPlayLimit, err = strconv.Atoi(repeatPlayLimitString)
+		if err != nil || repeatPlayLimit < 1 {
+			return nil, fmt.Errorf("REPEAT_PLAY_LIMIT environment variable must be a positive integer: %s", repeatPlayLimitString)
+		}
+	}
+
+	repeatPlayWindow := time.Hour
+	if repeatPlayWindowString := os.Getenv("REPEAT_PLAY_WINDOW"); repeatPlayWindowString != "" {
+		repeatPlayWindow, err = time.ParseDuration(repeatPlayWindowString)
+		if err != nil || repeatPlayWindow <= 0 {
+			return nil, fmt.Errorf("REPEAT_PLAY_WINDOW environment variable must be a positive duration: %s", repeatPlayWindowString)
+		}
+	}
+
+	audioRenditionsString, ok := os.LookupEnv("AUDIO_RENDITIONS")
+	if !ok {
+		audioRenditionsString = services.DefaultRenditionProfiles
+	}
+
+	audioRenditions, err := services.ParseRenditionProfiles(audioRenditionsString)
+	if err != nil {
+		return nil, fmt.Errorf("invalid AUDIO_RENDITIONS environment variable: %w", err)
+	}
+
+	uploadPolicy, err := services.LoadUploadPolicy()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load upload policy: %w", err)
+	}
+
+	usernamePolicy, err := services.LoadUsernamePolicy()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load username policy: %w", err)
+	}
+
+	// Zero lets users change their username at any time
+	usernameCooldown := 30 * 24 * time.Hour
+	if usernameCooldownString := os.Getenv("USERNAME_CHANGE_COOLDOWN"); usernameCooldownString != "" {
+		usernameCooldown, err = time.ParseDuration(usernameCooldownString)
+		if err != nil || usernameCooldown < 0 {
+			return nil, fmt.Errorf("USERNAME_CHANGE_COOLDOWN environment variable must be a non-negative duration: %s", usernameCooldownString)
+		}
+	}
+
+	draftTTL := time.Hour
+	if draftTTLString := os.Getenv("DRAFT_TTL"); draftTTLString != "" {
+		draftTTL, err = time.ParseDuration(draftTTLString)
+		if err != nil || draftTTL <= 0 {
+			return nil, fmt.Errorf("DRAFT_TTL environment variable must be a positive duration: %s", draftTTLString)
+		}
+	}
+
+	// Abandoned uploads keep their multipart parts in the upload bucket until they expire
+	uploadTTL := 24 * time.Hour
+	if uploadTTLString := os.Getenv("UPLOAD_TTL"); uploadTTLString != "" {
+		uploadTTL, err = time.ParseDuration(uploadTTLString)
+		if err != nil || uploadTTL <= 0 {
+			return nil, fmt.Errorf("UPLOAD_TTL environment variable must be a positive duration: %s", uploadTTLString)
+		}
+	}
+
+	// Matches the default token lifetime of the EdgeDB auth extension
+	sessionMaxAge := 14 * 24 * time.Hour
+	if sessionMaxAgeString := os.Getenv("AUTH_SESSION_MAX_AGE"); sessionMaxAgeString != "" {
+		sessionMaxAge, err = time.ParseDuration(sessionMaxAgeString)
+		if err != nil || sessionMaxAge <= 0 {
+			return nil, fmt.Errorf("AUTH_SESSION_MAX_AGE environment variable must be a positive duration: %s", sessionMaxAgeString)
+		}
+	}
+
+	objectGCGrace := 24 * time.Hour
+	if objectGCGraceString := os.Getenv("OBJECT_GC_GRACE_PERIOD"); objectGCGraceString != "" {
+		objectGCGrace, err = time.ParseDuration(objectGCGraceString)
+		if err != nil || objectGCGrace <= 0 {
+			return nil, fmt.Errorf("OBJECT_GC_GRACE_PERIOD environment variable must be a positive duration: %s", objectGCGraceString)
+		}
+	}
+
+	objectGCDryRun := false
+	if objectGCDryRunString := os.Getenv("OBJECT_GC_DRY_RUN"); objectGCDryRunString != "" {
+		objectGCDryRun, err = strconv.ParseBool(objectGCDryRunString)
+		if err != nil {
+			return nil, fmt.Errorf("OBJECT_GC_DRY_RUN environment variable must be a boolean: %s", objectGCDryRunString)
+		}
+	}
+
+	// Zero keeps aired stream audio forever
+	streamRetention := 30 * 24 * time.Hour
+	if streamRetentionString := os.Getenv("STREAM_AUDIO_RETENTION"); streamRetentionString != "" {
+		streamRetention, err = time.ParseDuration(streamRetentionString)
+		if err != nil || streamRetention < 0 {
+			return nil, fmt.Errorf("STREAM_AUDIO_RETENTION environment variable must be a non-negative duration: %s", streamRetentionString)
+		}
+	}
+
+	reportThreshold := int64(3)
+	if reportThresholdString := os.Getenv("REPORT_ESCALATION_THRESHOLD"); reportThresholdString != "" {
+		reportThreshold, err = strconv.ParseInt(reportThresholdString, 10, 64)
+		if err != nil || reportThreshold < 1 {
+			return nil, fmt.Errorf("REPORT_ESCALATION_THRESHOLD environment variable must be a positive integer: %s", reportThresholdString)
+		}
+	}
+
+	// Without any allowed origin, only same origin requests may use cookies to mutate
+	var allowedOrigins []string
+	if allowedOriginsString := os.Getenv("CORS_ALLOWED_ORIGINS"); allowedOriginsString != "" {
+		for _, allowedOriginString := range strings.Split(allowedOriginsString, ",") {
+			allowedOrigin, err := url.Parse(strings.TrimSpace(allowedOriginString))
+			if err != nil || allowedOrigin.Scheme == "" || allowedOrigin.Host == "" {
+				return nil, fmt.Errorf("CORS_ALLOWED_ORIGINS environment variable must be a comma separated list of origins: %s", allowedOriginsString)
+			}
+			allowedOrigins = append(allowedOrigins, allowedOrigin.Scheme+"://"+allowedOrigin.Host)
+		}
+	}
+
 	return &Handler{
 		DB:                 dbService,
-		S3:                 s3Service,
+		Blobs:              blobStore,
+		URLs:               urlBuilder,
 		Paddle:             paddleService,
+		Audio:              audioProcessor,
+		Image:              imageProcessor,
 		AuthPublicBaseURL:  edgedbAuthPublicBaseURL,
 		AuthPrivateBaseURL: edgedbAuthPrivateBaseURL,
+		AuthHTTPClient:     authHTTPClient,
+		RepeatPlayLimit:    repeatPlayLimit,
+		RepeatPlayWindow:   repeatPlayWindow,
+		AudioRenditions:    audioRenditions,
+		UploadPolicy:       uploadPolicy,
+		UsernamePolicy:     usernamePolicy,
+		UsernameCooldown:   usernameCooldown,
+		DraftTTL:           draftTTL,
+		UploadTTL:          uploadTTL,
+		SessionMaxAge:      sessionMaxAge,
+		ObjectGCGrace:      objectGCGrace,
+		ObjectGCDryRun:     objectGCDryRun,
+		StreamRetention:    streamRetention,
+		AllowedOrigins:     allowedOrigins,
+		SchedulerWake:      make(chan struct{}, 1),
+		ReportThreshold:    reportThreshold,
 	}, nil
 }
 
@@ -82,18 +281,59 @@ func validateData[T any](c echo.Context) (*T, error) {
 	return res, nil
 }
 
-func GetAuthToken(c echo.Context) (*string, error) {
-	authToken, ok := c.Get("authToken").(string)
+// Only public buckets get a static route, private objects are served to signed URLs only
+func LocalBlobRoutes(e *echo.Echo, store *services.LocalBlobStore) {
+	blobs := e.Group(store.PublicPath())
+	blobs.Static("/"+string(services.BucketAudio), store.BucketDir(services.BucketAudio))
+	blobs.Static("/"+string(services.BucketImage), store.BucketDir(services.BucketImage))
+	blobs.GET("/signed/*", echo.WrapHandler(http.StripPrefix(store.PublicPath()+"/signed", store)))
+}
+
+func GetAuthToken(c echo.Context) (*models.AuthToken, error) {
+	authToken, ok := c.Get("authToken").(models.AuthToken)
 	if !ok {
 		return nil, newEchoHTTPError(http.StatusUnauthorized, "Unauthorized: auth token not provided", nil)
 	}
 	return &authToken, nil
 }
 
-func newEchoHTTPError(code int, message string, err error) *echo.HTTPError {
+// The request ID is set by the RequestID middleware
+func auditSource(c echo.Context) models.AuditSource {
+	return models.AuditSource{
+		IP:        c.RealIP(),
+		RequestID: c.Response().Header().Get(echo.HeaderXRequestID),
+	}
+}
+
+func newEchoHTTPError(code int, message any, err error) *echo.HTTPError {
 	return echo.NewHTTPError(code, message).SetInternal(err)
 }
 
+func (h *Handler) imageURIs(imageKeys models.ImageKeys) map[string]string {
+	imageURIs := make(map[string]string, len(imageKeys))
+	for size, key := range imageKeys {
+		imageURIs[size] = h.URLs.URL(services.BucketImage, key)
+	}
+	return imageURIs
+}
+
+func (h *Handler) waveformURI(waveformKey edgedb.OptionalStr) *string {
+	key, ok := waveformKey.Get()
+	if !ok {
+		return nil
+	}
+	waveformURI := h.URLs.URL(services.BucketAudio, key)
+	return &waveformURI
+}
+
+func randomObjectName() (string, error) {
+	objectNameBytes := make([]byte, 16)
+	if _, err := rand.Read(objectNameBytes); err != nil {
+		return "", fmt.Errorf("failed to read random bytes: %w", err)
+	}
+	return hex.EncodeToString(objectNameBytes), nil
+}
+
 func SHA256File(filePath string) (string, error) {
 	f, err := os.Open(filePath)
 	if err != nil {
@@ -0,0 +1,292 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+	"world-sounds/models"
+	"world-sounds/services"
+
+	"github.com/edgedb/edgedb-go"
+	"github.com/labstack/echo/v4"
+)
+
+// Builds a handler around the Go processor and a filesystem blob store in a temporary directory. The database is only
+// set up by testDB, tests without it must fail before reaching the database
+func testHandler(t *testing.T) *Handler {
+	t.Helper()
+
+	t.Setenv("BLOB_DIR", t.TempDir())
+	t.Setenv("BLOB_PUBLIC_URL", "http://localhost:3000/blobs")
+	blobs, err := services.NewLocalBlobStore()
+	if err != nil {
+		t.Fatalf("failed to create blob store: %v", err)
+	}
+
+	urls, err := services.NewCDNURLBuilder(blobs)
+	if err != nil {
+		t.Fatalf("failed to create URL builder: %v", err)
+	}
+
+	processor := services.NewGoProcessor()
+	return &Handler{
+		Blobs:            blobs,
+		URLs:             urls,
+		Audio:            processor,
+		Image:            processor,
+		RepeatPlayLimit:  3,
+		RepeatPlayWindow: 24 * time.Hour,
+		UploadPolicy:     services.DefaultUploadPolicy(),
+	}
+}
+
+// Connects to the database at EDGEDB_TEST_DSN, which has to be a disposable database with the migrations applied since
+// tests leave users behind
+func testDB(t *testing.T, h *Handler) {
+	t.Helper()
+
+	dsn := os.Getenv("EDGEDB_TEST_DSN")
+	if dsn == "" {
+		t.Skip("EDGEDB_TEST_DSN is not set")
+	}
+
+	t.Setenv("EDGEDB_DSN", dsn)
+	db, err := models.NewDBService()
+	if err != nil {
+		t.Fatalf("failed to connect to database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	h.DB = db
+}
+
+// Inserts a user with its own identity and returns an auth token acting as that user
+func testUser(t *testing.T, h *Handler, credits int64) models.AuthToken {
+	t.Helper()
+
+	suffix := make([]byte, 8)
+	if _, err := rand.Read(suffix); err != nil {
+		t.Fatal(err)
+	}
+
+	var user struct {
+		ID edgedb.UUID `edgedb:"id"`
+	}
+	err := h.DB.QuerySingle(
+		context.Background(),
+		`INSERT User {
+			username := <str>$username,
+			credits := <int64>$credits,
+			identity := (INSERT ext::auth::Identity {
+				issuer := 'https://test.invalid',
+				subject := <str>$username
+			})
+		}`,
+		&user,
+		map[string]interface{}{
+			"username": "test-" + hex.EncodeToString(suffix),
+			"credits":  credits,
+		},
+	)
+	if err != nil {
+		t.Fatalf("failed to create user: %v", err)
+	}
+
+	// Queued bids would count as repeat plays of the same test audio in later runs
+	t.Cleanup(func() {
+		err := h.DB.Execute(context.Background(), `DELETE Bid FILTER .user.id = <uuid>$id`, map[string]interface{}{"id": user.ID})
+		if err != nil {
+			t.Errorf("failed to delete bids: %v", err)
+		}
+	})
+
+	return models.AuthToken{UserID: user.ID}
+}
+
+func testSetRoles(t *testing.T, h *Handler, authToken models.AuthToken, roles ...string) {
+	t.Helper()
+
+	err := h.DB.Execute(
+		context.Background(),
+		`UPDATE User FILTER .id = <uuid>$id SET { roles := <Role>array_unpack(<array<str>>$roles) }`,
+		map[string]interface{}{"id": authToken.UserID, "roles": roles},
+	)
+	if err != nil {
+		t.Fatalf("failed to set roles: %v", err)
+	}
+}
+
+// Mono 16-bit WAV with a sawtooth, the seed changes the pitch so different seeds don't share a fingerprint
+func testWAV(seconds int, seed int) []byte {
+	const sampleRate = 8000
+
+	period := 20 + seed
+	samples := make([]byte, 0, 2*seconds*sampleRate)
+	for i := 0; i < seconds*sampleRate; i++ {
+		samples = binary.LittleEndian.AppendUint16(samples, uint16(int16((i%period)*30000/period-15000)))
+	}
+
+	wav := []byte("RIFF")
+	wav = binary.LittleEndian.AppendUint32(wav, uint32(36+len(samples)))
+	wav = append(wav, "WAVEfmt "...)
+	wav = binary.LittleEndian.AppendUint32(wav, 16)
+	wav = binary.LittleEndian.AppendUint16(wav, 1)
+	wav = binary.LittleEndian.AppendUint16(wav, 1)
+	wav = binary.LittleEndian.AppendUint32(wav, sampleRate)
+	wav = binary.LittleEndian.AppendUint32(wav, 2*sampleRate)
+	wav = binary.LittleEndian.AppendUint16(wav, 2)
+	wav = binary.LittleEndian.AppendUint16(wav, 16)
+	wav = append(wav, "data"...)
+	wav = binary.LittleEndian.AppendUint32(wav, uint32(len(samples)))
+	return append(wav, samples...)
+}
+
+func testPNG(t *testing.T, width int, height int) []byte {
+	t.Helper()
+
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for x := 0; x < width; x++ {
+		for y := 0; y < height; y++ {
+			img.Set(x, y, color.RGBA{uint8(x), uint8(y), 128, 255})
+		}
+	}
+
+	var buffer bytes.Buffer
+	if err := png.Encode(&buffer, img); err != nil {
+		t.Fatal(err)
+	}
+	return buffer.Bytes()
+}
+
+// Builds a multipart form request, files are given by field name and uploaded as "upload"
+func testMultipartRequest(t *testing.T, method string, target string, fields map[string]string, files map[string][]byte) *http.Request {
+	t.Helper()
+
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	for name, value := range fields {
+		if err := writer.WriteField(name, value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for name, content := range files {
+		part, err := writer.CreateFormFile(name, "upload")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.Copy(part, bytes.NewReader(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest(method, target, &body)
+	req.Header.Set(echo.HeaderContentType, writer.FormDataContentType())
+	return req
+}
+
+// Runs the handler and returns the response code, turning returned HTTP errors into their code like echo would
+func testServe(t *testing.T, handler echo.HandlerFunc, req *http.Request, authToken *models.AuthToken) (int, *httptest.ResponseRecorder) {
+	t.Helper()
+
+	rec := httptest.NewRecorder()
+	c := echo.New().NewContext(req, rec)
+	if authToken != nil {
+		c.Set("authToken", *authToken)
+	}
+
+	err := handler(c)
+	if err == nil {
+		return rec.Code, rec
+	}
+
+	var httpError *echo.HTTPError
+	if !errors.As(err, &httpError) {
+		t.Fatalf("handler failed: %v", err)
+	}
+	return httpError.Code, rec
+}
+
+func httpErrorCode(t *testing.T, err error) int {
+	t.Helper()
+
+	var httpError *echo.HTTPError
+	if !errors.As(err, &httpError) {
+		t.Fatalf("want an HTTP error, got %v", err)
+	}
+	return httpError.Code
+}
+
+// Requests a URL handed out by the handler from the local blob store routes main.go mounts
+func testFetchBlob(t *testing.T, h *Handler, blobURL string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	parsedURL, err := url.Parse(blobURL)
+	if err != nil {
+		t.Fatalf("failed to parse blob URL: %v", err)
+	}
+
+	e := echo.New()
+	LocalBlobRoutes(e, h.Blobs.(*services.LocalBlobStore))
+
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, parsedURL.RequestURI(), nil))
+	return rec
+}
+
+func TestLocalBlobRoutes(t *testing.T) {
+	h := testHandler(t)
+	ctx := context.Background()
+
+	audio := testWAV(1, 0)
+	if err := h.Blobs.Put(ctx, services.BucketPrivateAudio, "audio.wav", bytes.NewReader(audio), int64(len(audio)), "audio/wav"); err != nil {
+		t.Fatal(err)
+	}
+	avatar := testPNG(t, 8, 8)
+	if err := h.Blobs.Put(ctx, services.BucketImage, "avatar.png", bytes.NewReader(avatar), int64(len(avatar)), "image/png"); err != nil {
+		t.Fatal(err)
+	}
+
+	audioURI, err := h.signAudio(ctx, "audio.wav", nil, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rec := testFetchBlob(t, h, audioURI)
+	if rec.Code != http.StatusOK || !bytes.Equal(rec.Body.Bytes(), audio) {
+		t.Errorf("signed audio URL got %d with %d bytes, want %d with %d bytes", rec.Code, rec.Body.Len(), http.StatusOK, len(audio))
+	}
+
+	tamperedURI := strings.Replace(audioURI, "signature=", "signature=0", 1)
+	if rec := testFetchBlob(t, h, tamperedURI); rec.Code != http.StatusForbidden {
+		t.Errorf("tampered audio URL got %d, want %d", rec.Code, http.StatusForbidden)
+	}
+
+	privateURI := h.Blobs.PublicURL(services.BucketPrivateAudio, "audio.wav")
+	if rec := testFetchBlob(t, h, privateURI); rec.Code != http.StatusNotFound {
+		t.Errorf("unsigned private audio URL got %d, want %d", rec.Code, http.StatusNotFound)
+	}
+
+	imageURI := h.imageURIs(models.ImageKeys{"8": "avatar.png"})["8"]
+	rec = testFetchBlob(t, h, imageURI)
+	if rec.Code != http.StatusOK || !bytes.Equal(rec.Body.Bytes(), avatar) {
+		t.Errorf("image URL got %d with %d bytes, want %d with %d bytes", rec.Code, rec.Body.Len(), http.StatusOK, len(avatar))
+	}
+}
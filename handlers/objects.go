@@ -0,0 +1,185 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+	"world-sounds/models"
+	"world-sounds/services"
+
+	"github.com/edgedb/edgedb-go"
+)
+
+// Drafts used to keep their source and preview under this prefix of the upload bucket, nothing references those anymore
+const legacyDraftObjectPrefix = "drafts/"
+
+type ObjectsCollectReport struct {
+	DryRun       bool
+	Scanned      int
+	Unreferenced []ObjectsCollectEntry
+	Removed      int
+	RemovedBytes int64
+}
+
+type ObjectsCollectEntry struct {
+	Bucket       services.Bucket
+	Key          string
+	Size         int64
+	LastModified time.Time
+}
+
+// Deletes audio and image objects no longer referenced by a bid, a draft, a stream within the retention period or a
+// user, along with leftover draft objects of the upload bucket. Objects younger than the grace period are kept, as
+// they may belong to a bid, draft or avatar whose transaction hasn't committed yet. In dry run mode the unreferenced
+// objects are only reported
+func (h *Handler) ObjectsCollect(ctx context.Context, dryRun bool) (*ObjectsCollectReport, error) {
+	var streamsSince edgedb.OptionalDateTime
+	if h.StreamRetention > 0 {
+		streamsSince = edgedb.NewOptionalDateTime(time.Now().Add(-h.StreamRetention))
+	}
+
+	var objects *models.ObjectsReferencedFetchResult
+	err := models.GetTx(h.DB, nil)(ctx, func(ctx context.Context, tx *edgedb.Tx) error {
+		var err error
+		objects, err = models.ObjectsReferencedFetch(ctx, tx, streamsSince)
+		if err != nil {
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch referenced objects: %w", err)
+	}
+
+	referenced := map[services.Bucket]map[string]struct{}{
+		services.BucketAudio:        {},
+		services.BucketPrivateAudio: {},
+		services.BucketImage:        {},
+		services.BucketUpload:       {},
+	}
+	// Audio uploaded before it was kept private may still wait in the public bucket for LegacyAudioMove, so it counts
+	// as referenced there too
+	for _, key := range objects.AudioKeys {
+		referenced[services.BucketPrivateAudio][key] = struct{}{}
+		referenced[services.BucketAudio][key] = struct{}{}
+	}
+	for _, key := range objects.WaveformKeys {
+		referenced[services.BucketAudio][key] = struct{}{}
+	}
+	for _, key := range objects.ImageKeys {
+		referenced[services.BucketImage][key] = struct{}{}
+	}
+
+	report := &ObjectsCollectReport{DryRun: dryRun}
+	graceCutoff := time.Now().Add(-h.ObjectGCGrace)
+
+	for bucket, bucketReferenced := range referenced {
+		err := h.Blobs.List(ctx, bucket, func(key string, info *services.BlobInfo) error {
+			// Objects of resumable uploads are cleaned up with their uploads
+			if bucket == services.BucketUpload && !strings.HasPrefix(key, legacyDraftObjectPrefix) {
+				return nil
+			}
+
+			report.Scanned++
+
+			if info.LastModified.After(graceCutoff) {
+				return nil
+			}
+
+			if _, ok := bucketReferenced[key]; ok {
+				return nil
+			}
+
+			report.Unreferenced = append(report.Unreferenced, ObjectsCollectEntry{
+				Bucket:       bucket,
+				Key:          key,
+				Size:         info.Size,
+				LastModified: info.LastModified,
+			})
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if dryRun {
+		return report, nil
+	}
+
+	for _, entry := range report.Unreferenced {
+		err := h.Blobs.Delete(ctx, entry.Bucket, entry.Key)
+		if err != nil {
+			slog.Error("Failed to remove unreferenced object", slog.Any("err", err), slog.String("bucket", string(entry.Bucket)), slog.String("key", entry.Key))
+			continue
+		}
+
+		report.Removed++
+		report.RemovedBytes += entry.Size
+	}
+
+	return report, nil
+}
+
+// Moves the audio and renditions of bids and streams from before audio was kept private out of the public bucket, so
+// they can be signed for playback again. Objects already in the private bucket are skipped, so it is safe to run again
+func (h *Handler) LegacyAudioMove(ctx context.Context) (int, error) {
+	var objects *models.ObjectsReferencedFetchResult
+	err := models.GetTx(h.DB, nil)(ctx, func(ctx context.Context, tx *edgedb.Tx) error {
+		var err error
+		objects, err = models.ObjectsReferencedFetch(ctx, tx, edgedb.OptionalDateTime{})
+		if err != nil {
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to fetch referenced objects: %w", err)
+	}
+
+	moved := 0
+	for _, key := range objects.AudioKeys {
+		_, err := h.Blobs.Stat(ctx, services.BucketPrivateAudio, key)
+		if err == nil {
+			continue
+		}
+		if !errors.Is(err, services.ErrBlobNotFound) {
+			return moved, err
+		}
+
+		info, err := h.Blobs.Stat(ctx, services.BucketAudio, key)
+		if errors.Is(err, services.ErrBlobNotFound) {
+			continue
+		}
+		if err != nil {
+			return moved, err
+		}
+
+		err = h.moveObject(ctx, services.BucketAudio, services.BucketPrivateAudio, key, info)
+		if err != nil {
+			return moved, err
+		}
+		moved++
+	}
+
+	return moved, nil
+}
+
+// The source is only deleted once the copy is complete
+func (h *Handler) moveObject(ctx context.Context, from services.Bucket, to services.Bucket, key string, info *services.BlobInfo) error {
+	src, err := h.Blobs.Get(ctx, from, key)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	err = h.Blobs.Put(ctx, to, key, src, info.Size, info.ContentType)
+	if err != nil {
+		return fmt.Errorf("failed to copy %s object to %s: %w", from, to, err)
+	}
+
+	return h.Blobs.Delete(ctx, from, key)
+}
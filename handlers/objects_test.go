@@ -0,0 +1,36 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"world-sounds/services"
+)
+
+func TestObjectsCollectLeftoverDraftObjects(t *testing.T) {
+	h := testHandler(t)
+	testDB(t, h)
+	ctx := context.Background()
+
+	for _, key := range []string{"drafts/0123456789abcdef/source", "0123456789abcdef"} {
+		if err := h.Blobs.Put(ctx, services.BucketUpload, key, bytes.NewReader([]byte("audio")), 5, "application/octet-stream"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	report, err := h.ObjectsCollect(ctx, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Removed != 1 {
+		t.Errorf("removed %d objects, want 1", report.Removed)
+	}
+
+	if _, err := h.Blobs.Stat(ctx, services.BucketUpload, "drafts/0123456789abcdef/source"); !errors.Is(err, services.ErrBlobNotFound) {
+		t.Errorf("leftover draft object wasn't collected: %v", err)
+	}
+	if _, err := h.Blobs.Stat(ctx, services.BucketUpload, "0123456789abcdef"); err != nil {
+		t.Errorf("upload object was collected: %v", err)
+	}
+}
@@ -0,0 +1,246 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"world-sounds/models"
+
+	"github.com/edgedb/edgedb-go"
+	"github.com/labstack/echo/v4"
+)
+
+// Wakes the scheduler of this replica so it doesn't wait for its timeout, other replicas catch up on their next run
+func (h *Handler) wakeScheduler() {
+	select {
+	case h.SchedulerWake <- struct{}{}:
+	default:
+	}
+}
+
+type AdminStreamSkipData struct {
+	Refund bool   `json:"refund"`
+	Reason string `json:"reason" validate:"required,max=500"`
+}
+
+func (h *Handler) AdminStreamSkip(c echo.Context) error {
+	authToken, err := GetAuthToken(c)
+	if err != nil {
+		return err
+	}
+
+	data, err := validateData[AdminStreamSkipData](c)
+	if err != nil {
+		return err
+	}
+
+	var stream *models.StreamSkipResult
+	err = models.GetTx(h.DB, authToken)(c.Request().Context(), func(ctx context.Context, tx *edgedb.Tx) error {
+		stream, err = models.StreamSkip(ctx, tx, edgedb.OptionalUUID{})
+		if err != nil {
+			return err
+		}
+		if stream == nil {
+			return nil
+		}
+
+		var refundedCredits int64
+		if data.Refund {
+			_, err = models.UserIncrementCredits(ctx, tx, stream.User.ID, stream.Credits)
+			if err != nil {
+				return err
+			}
+			refundedCredits = stream.Credits
+		}
+
+		return models.AuditEventCreate(ctx, tx, auditSource(c), "stream.skip", models.AuditTargetStream, edgedb.NewOptionalUUID(stream.ID), data.Reason, stream, map[string]any{
+			"refunded_credits": refundedCredits,
+		})
+	})
+	if err != nil {
+		return fmt.Errorf("failed to skip stream: %w", err)
+	}
+
+	if stream == nil {
+		return newEchoHTTPError(http.StatusConflict, "no stream is airing", nil)
+	}
+
+	h.wakeScheduler()
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+type AdminBidsDeleteData struct {
+	BidID  edgedb.UUID `param:"id" validate:"required"`
+	Refund bool        `json:"refund"`
+	Reason string      `json:"reason" validate:"required,max=500"`
+}
+
+func (h *Handler) AdminBidsDelete(c echo.Context) error {
+	authToken, err := GetAuthToken(c)
+	if err != nil {
+		return err
+	}
+
+	data, err := validateData[AdminBidsDeleteData](c)
+	if err != nil {
+		return err
+	}
+
+	err = models.GetTx(h.DB, authToken)(c.Request().Context(), func(ctx context.Context, tx *edgedb.Tx) error {
+		bid, err := models.BidRemove(ctx, tx, data.BidID)
+		if err != nil {
+			return err
+		}
+
+		var refundedCredits int64
+		if data.Refund {
+			_, err = models.UserIncrementCredits(ctx, tx, bid.User.ID, bid.Credits)
+			if err != nil {
+				return err
+			}
+			refundedCredits = bid.Credits
+		}
+
+		return models.AuditEventCreate(ctx, tx, auditSource(c), "bid.delete", models.AuditTargetBid, edgedb.NewOptionalUUID(bid.ID), data.Reason, bid, map[string]any{
+			"refunded_credits": refundedCredits,
+		})
+	})
+	if errors.Is(err, models.ErrBidNotFound) {
+		return newEchoHTTPError(http.StatusNotFound, "bid does not exist", err)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to delete bid: %w", err)
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+type AdminBidPinData struct {
+	BidID  edgedb.UUID `param:"id" validate:"required"`
+	Reason string      `json:"reason" validate:"max=500"`
+}
+
+func (h *Handler) AdminBidPin(c echo.Context) error {
+	return h.updateBidPinned(c, true)
+}
+
+func (h *Handler) AdminBidUnpin(c echo.Context) error {
+	return h.updateBidPinned(c, false)
+}
+
+func (h *Handler) updateBidPinned(c echo.Context, pinned bool) error {
+	authToken, err := GetAuthToken(c)
+	if err != nil {
+		return err
+	}
+
+	data, err := validateData[AdminBidPinData](c)
+	if err != nil {
+		return err
+	}
+
+	action := "bid.unpin"
+	if pinned {
+		action = "bid.pin"
+	}
+
+	err = models.GetTx(h.DB, authToken)(c.Request().Context(), func(ctx context.Context, tx *edgedb.Tx) error {
+		err := models.BidUpdatePinned(ctx, tx, data.BidID, pinned)
+		if err != nil {
+			return err
+		}
+
+		return models.AuditEventCreate(ctx, tx, auditSource(c), action, models.AuditTargetBid, edgedb.NewOptionalUUID(data.BidID), data.Reason, map[string]any{
+			"pinned": !pinned,
+		}, map[string]any{
+			"pinned": pinned,
+		})
+	})
+	if errors.Is(err, models.ErrBidNotFound) {
+		return newEchoHTTPError(http.StatusNotFound, "bid does not exist", err)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to update bid: %w", err)
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+func (h *Handler) AdminSchedulerFetch(c echo.Context) error {
+	authToken, err := GetAuthToken(c)
+	if err != nil {
+		return err
+	}
+
+	var scheduler *models.SchedulerStateFetchResult
+	err = models.GetTx(h.DB, authToken)(c.Request().Context(), func(ctx context.Context, tx *edgedb.Tx) error {
+		scheduler, err = models.SchedulerStateFetch(ctx, tx)
+		if err != nil {
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to fetch scheduler state: %w", err)
+	}
+
+	return c.JSON(http.StatusOK, scheduler)
+}
+
+type AdminSchedulerUpdateData struct {
+	Reason string `json:"reason" validate:"max=500"`
+}
+
+func (h *Handler) AdminSchedulerPause(c echo.Context) error {
+	return h.updateSchedulerPaused(c, true)
+}
+
+func (h *Handler) AdminSchedulerResume(c echo.Context) error {
+	return h.updateSchedulerPaused(c, false)
+}
+
+func (h *Handler) updateSchedulerPaused(c echo.Context, paused bool) error {
+	authToken, err := GetAuthToken(c)
+	if err != nil {
+		return err
+	}
+
+	data, err := validateData[AdminSchedulerUpdateData](c)
+	if err != nil {
+		return err
+	}
+
+	action := "scheduler.resume"
+	if paused {
+		action = "scheduler.pause"
+	}
+
+	err = models.GetTx(h.DB, authToken)(c.Request().Context(), func(ctx context.Context, tx *edgedb.Tx) error {
+		scheduler, err := models.SchedulerStateFetch(ctx, tx)
+		if err != nil {
+			return err
+		}
+
+		err = models.SchedulerStateUpdate(ctx, tx, paused)
+		if err != nil {
+			return err
+		}
+
+		return models.AuditEventCreate(ctx, tx, auditSource(c), action, models.AuditTargetScheduler, edgedb.OptionalUUID{}, data.Reason, map[string]any{
+			"paused": scheduler.Paused,
+		}, map[string]any{
+			"paused": paused,
+		})
+	})
+	if err != nil {
+		return fmt.Errorf("failed to update scheduler state: %w", err)
+	}
+
+	if !paused {
+		h.wakeScheduler()
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
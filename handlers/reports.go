@@ -0,0 +1,282 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"world-sounds/models"
+
+	"github.com/edgedb/edgedb-go"
+	"github.com/labstack/echo/v4"
+)
+
+type ReportsCreateData struct {
+	StreamID edgedb.UUID `param:"id" validate:"required"`
+	Category string      `json:"category" validate:"required,oneof=spam hate harassment sexual violence copyright other"`
+	Details  string      `json:"details" validate:"max=1000"`
+}
+
+// Once a stream reaches the report threshold it is skipped if still airing, and the queued bids of its bidder are
+// held until a moderator reviews the reports
+func (h *Handler) ReportsCreate(c echo.Context) error {
+	authToken, err := GetAuthToken(c)
+	if err != nil {
+		return err
+	}
+
+	data, err := validateData[ReportsCreateData](c)
+	if err != nil {
+		return err
+	}
+
+	var details edgedb.OptionalStr
+	if data.Details != "" {
+		details = edgedb.NewOptionalStr(data.Details)
+	}
+
+	var report *models.ReportCreateResult
+	var skipped bool
+	err = models.GetTx(h.DB, authToken)(c.Request().Context(), func(ctx context.Context, tx *edgedb.Tx) error {
+		stream, err := models.StreamOwnerFetch(ctx, tx, data.StreamID)
+		if err != nil {
+			return err
+		}
+
+		if stream.Own {
+			return newEchoHTTPError(http.StatusBadRequest, "can't report your own stream", nil)
+		}
+
+		report, err = models.ReportCreate(ctx, tx, data.StreamID, data.Category, details)
+		if err != nil {
+			return err
+		}
+
+		if !report.Created {
+			return nil
+		}
+
+		pendingReports, err := models.StreamPendingReportsCount(ctx, tx, data.StreamID)
+		if err != nil {
+			return err
+		}
+
+		// Only the report reaching the threshold escalates, later ones have nothing left to do
+		if pendingReports != h.ReportThreshold {
+			return nil
+		}
+
+		skippedStream, err := models.StreamSkip(ctx, tx, edgedb.NewOptionalUUID(data.StreamID))
+		if err != nil {
+			return err
+		}
+		skipped = skippedStream != nil
+
+		heldBids, err := models.BidsHold(ctx, tx, stream.User.ID)
+		if err != nil {
+			return err
+		}
+
+		// The escalation is the system's doing, not the reporter's, so it's recorded without the reporter or their IP
+		source := models.AuditSource{RequestID: auditSource(c).RequestID, System: true}
+		return models.AuditEventCreate(ctx, tx, source, "report.escalate", models.AuditTargetStream, edgedb.NewOptionalUUID(data.StreamID), "", nil, map[string]any{
+			"pending_reports": pendingReports,
+			"skipped":         skipped,
+			"held_bids":       heldBids,
+		})
+	})
+	if errors.Is(err, models.ErrStreamNotFound) {
+		return newEchoHTTPError(http.StatusNotFound, "stream does not exist", err)
+	}
+	var httpError *echo.HTTPError
+	if errors.As(err, &httpError) {
+		return httpError
+	}
+	if err != nil {
+		return fmt.Errorf("failed to create report: %w", err)
+	}
+
+	if skipped {
+		h.wakeScheduler()
+	}
+
+	if !report.Created {
+		return c.JSON(http.StatusOK, report)
+	}
+	return c.JSON(http.StatusCreated, report)
+}
+
+type AdminReportsFetchData struct {
+	Status string `query:"status" validate:"omitempty,oneof=pending dismissed upheld"`
+	Offset int64  `query:"offset"`
+	Limit  int64  `query:"limit"`
+}
+
+func (h *Handler) AdminReportsFetch(c echo.Context) error {
+	authToken, err := GetAuthToken(c)
+	if err != nil {
+		return err
+	}
+
+	data, err := validateData[AdminReportsFetchData](c)
+	if err != nil {
+		return err
+	}
+
+	if data.Status == "" {
+		data.Status = "pending"
+	}
+
+	if data.Offset < 0 {
+		return newEchoHTTPError(http.StatusBadRequest, "offset must be greater than or equal to 0", nil)
+	}
+
+	if data.Limit == 0 {
+		data.Limit = 20
+	} else if data.Limit < 1 || data.Limit > 100 {
+		return newEchoHTTPError(http.StatusBadRequest, "limit must be between 1 and 100", nil)
+	}
+
+	var reports []models.ReportsFetchResult
+	err = models.GetTx(h.DB, authToken)(c.Request().Context(), func(ctx context.Context, tx *edgedb.Tx) error {
+		reports, err = models.ReportsFetch(ctx, tx, data.Status, data.Offset, data.Limit)
+		if err != nil {
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to fetch reports: %w", err)
+	}
+
+	return c.JSON(http.StatusOK, reports)
+}
+
+type AdminReportDismissData struct {
+	ReportID edgedb.UUID `param:"id" validate:"required"`
+	Reason   string      `json:"reason" validate:"required,max=500"`
+}
+
+// Dismisses every pending report of the stream and releases the held bids of its bidder
+func (h *Handler) AdminReportDismiss(c echo.Context) error {
+	authToken, err := GetAuthToken(c)
+	if err != nil {
+		return err
+	}
+
+	data, err := validateData[AdminReportDismissData](c)
+	if err != nil {
+		return err
+	}
+
+	err = models.GetTx(h.DB, authToken)(c.Request().Context(), func(ctx context.Context, tx *edgedb.Tx) error {
+		resolved, err := models.ReportsResolve(ctx, tx, data.ReportID, "dismissed", data.Reason)
+		if err != nil {
+			return err
+		}
+
+		releasedBids, err := models.BidsRelease(ctx, tx, resolved.StreamUserID, h.ReportThreshold)
+		if err != nil {
+			return err
+		}
+
+		return models.AuditEventCreate(ctx, tx, auditSource(c), "report.dismiss", models.AuditTargetReport, edgedb.NewOptionalUUID(data.ReportID), data.Reason, nil, map[string]any{
+			"stream_id":     resolved.StreamID,
+			"resolved":      resolved.Resolved,
+			"released_bids": releasedBids,
+		})
+	})
+	if errors.Is(err, models.ErrReportNotFound) {
+		return newEchoHTTPError(http.StatusNotFound, "report does not exist", err)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to dismiss report: %w", err)
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+type AdminReportUpholdData struct {
+	ReportID   edgedb.UUID `param:"id" validate:"required"`
+	Reason     string      `json:"reason" validate:"required,max=500"`
+	RemoveBids bool        `json:"remove_bids"`
+	RefundBids bool        `json:"refund_bids"`
+}
+
+// Upholds every pending report of the stream and skips it if still airing. The queued bids of the bidder are either
+// removed, optionally with a refund, or released back into the queue
+func (h *Handler) AdminReportUphold(c echo.Context) error {
+	authToken, err := GetAuthToken(c)
+	if err != nil {
+		return err
+	}
+
+	data, err := validateData[AdminReportUpholdData](c)
+	if err != nil {
+		return err
+	}
+
+	var skipped bool
+	err = models.GetTx(h.DB, authToken)(c.Request().Context(), func(ctx context.Context, tx *edgedb.Tx) error {
+		resolved, err := models.ReportsResolve(ctx, tx, data.ReportID, "upheld", data.Reason)
+		if err != nil {
+			return err
+		}
+
+		skippedStream, err := models.StreamSkip(ctx, tx, edgedb.NewOptionalUUID(resolved.StreamID))
+		if err != nil {
+			return err
+		}
+		skipped = skippedStream != nil
+
+		after := map[string]any{
+			"stream_id": resolved.StreamID,
+			"resolved":  resolved.Resolved,
+			"skipped":   skipped,
+		}
+
+		if data.RemoveBids {
+			bids, err := models.BidsRemoveByUser(ctx, tx, resolved.StreamUserID)
+			if err != nil {
+				return err
+			}
+
+			var refundedCredits int64
+			if data.RefundBids {
+				for _, bid := range bids {
+					refundedCredits += bid.Credits
+				}
+				if refundedCredits > 0 {
+					_, err = models.UserIncrementCredits(ctx, tx, resolved.StreamUserID, refundedCredits)
+					if err != nil {
+						return err
+					}
+				}
+			}
+
+			after["removed_bids"] = bids
+			after["refunded_credits"] = refundedCredits
+		} else {
+			releasedBids, err := models.BidsRelease(ctx, tx, resolved.StreamUserID, h.ReportThreshold)
+			if err != nil {
+				return err
+			}
+
+			after["released_bids"] = releasedBids
+		}
+
+		return models.AuditEventCreate(ctx, tx, auditSource(c), "report.uphold", models.AuditTargetReport, edgedb.NewOptionalUUID(data.ReportID), data.Reason, nil, after)
+	})
+	if errors.Is(err, models.ErrReportNotFound) {
+		return newEchoHTTPError(http.StatusNotFound, "report does not exist", err)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to uphold report: %w", err)
+	}
+
+	if skipped {
+		h.wakeScheduler()
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
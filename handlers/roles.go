@@ -0,0 +1,65 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"slices"
+	"world-sounds/models"
+
+	"github.com/edgedb/edgedb-go"
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	RoleAdmin     = "admin"
+	RoleModerator = "moderator"
+)
+
+// Stores the roles of the caller in the context for RequireRole, callers without an auth token get none
+func (h *Handler) ResolveRoles(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		authToken, ok := c.Get("authToken").(models.AuthToken)
+		if !ok {
+			c.Set("roles", []string{})
+			return next(c)
+		}
+
+		var roles []string
+		err := models.GetTx(h.DB, &authToken)(c.Request().Context(), func(ctx context.Context, tx *edgedb.Tx) error {
+			var err error
+			roles, err = models.UserRolesFetch(ctx, tx)
+			if err != nil {
+				return err
+			}
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("failed to fetch roles: %w", err)
+		}
+
+		c.Set("roles", roles)
+
+		return next(c)
+	}
+}
+
+// Requires any of the roles, must run after ResolveRoles
+func RequireRole(roles ...string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if _, ok := c.Get("authToken").(models.AuthToken); !ok {
+				return newEchoHTTPError(http.StatusUnauthorized, "Unauthorized: auth token not provided", nil)
+			}
+
+			callerRoles, _ := c.Get("roles").([]string)
+			for _, role := range roles {
+				if slices.Contains(callerRoles, role) {
+					return next(c)
+				}
+			}
+
+			return newEchoHTTPError(http.StatusForbidden, "Forbidden: missing role", nil)
+		}
+	}
+}
@@ -0,0 +1,136 @@
+package handlers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"world-sounds/models"
+
+	"github.com/edgedb/edgedb-go"
+	"github.com/labstack/echo/v4"
+)
+
+const AuthTokenCookieName = "edgedb-auth-token"
+
+// Sessions are looked up by a hash, so a database leak doesn't leak usable tokens
+func hashAuthToken(authToken string) string {
+	hash := sha256.Sum256([]byte(authToken))
+	return hex.EncodeToString(hash[:])
+}
+
+func (h *Handler) setAuthTokenCookie(c echo.Context, authToken string) {
+	c.SetCookie(&http.Cookie{
+		Name:     AuthTokenCookieName,
+		Value:    authToken,
+		HttpOnly: true,
+		Path:     "/",
+		Secure:   true,
+		SameSite: http.SameSiteLaxMode,
+		MaxAge:   int(h.SessionMaxAge.Seconds()),
+	})
+}
+
+func ClearAuthTokenCookie(c echo.Context) {
+	c.SetCookie(&http.Cookie{
+		Name:     AuthTokenCookieName,
+		Value:    "",
+		HttpOnly: true,
+		Path:     "/",
+		Secure:   true,
+		SameSite: http.SameSiteLaxMode,
+		MaxAge:   -1,
+	})
+}
+
+// Returns whether the session of the token was revoked or expired, and records when and from where it was last used.
+// Tokens without a session count as revoked, so tokens issued before sessions were tracked have to sign in again and
+// can't escape being listed and revoked
+func (h *Handler) SessionTouch(ctx context.Context, authToken string, ip string) (bool, error) {
+	var session *models.SessionTouchResult
+	err := models.GetTx(h.DB, nil)(ctx, func(ctx context.Context, tx *edgedb.Tx) error {
+		var err error
+		session, err = models.SessionTouch(ctx, tx, hashAuthToken(authToken), ip)
+		if err != nil {
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to touch session: %w", err)
+	}
+
+	return session == nil || session.Revoked, nil
+}
+
+func (h *Handler) AuthSignOut(c echo.Context) error {
+	authToken, ok := c.Get("authToken").(models.AuthToken)
+	if ok && authToken.ClientToken != "" {
+		err := models.GetTx(h.DB, nil)(c.Request().Context(), func(ctx context.Context, tx *edgedb.Tx) error {
+			return models.SessionRevokeByToken(ctx, tx, hashAuthToken(authToken.ClientToken))
+		})
+		if err != nil {
+			return fmt.Errorf("failed to revoke session: %w", err)
+		}
+	}
+
+	ClearAuthTokenCookie(c)
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+func (h *Handler) SessionsFetch(c echo.Context) error {
+	authToken, err := GetAuthToken(c)
+	if err != nil {
+		return err
+	}
+
+	var sessions []models.SessionsFetchResult
+	err = models.GetTx(h.DB, authToken)(c.Request().Context(), func(ctx context.Context, tx *edgedb.Tx) error {
+		sessions, err = models.SessionsFetch(ctx, tx)
+		if err != nil {
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to fetch sessions: %w", err)
+	}
+
+	currentTokenHash := hashAuthToken(authToken.ClientToken)
+	for i := range sessions {
+		sessions[i].Current = sessions[i].TokenHash == currentTokenHash
+	}
+
+	return c.JSON(http.StatusOK, sessions)
+}
+
+type SessionsDeleteData struct {
+	SessionID edgedb.UUID `param:"id" validate:"required"`
+}
+
+func (h *Handler) SessionsDelete(c echo.Context) error {
+	authToken, err := GetAuthToken(c)
+	if err != nil {
+		return err
+	}
+
+	data, err := validateData[SessionsDeleteData](c)
+	if err != nil {
+		return err
+	}
+
+	err = models.GetTx(h.DB, authToken)(c.Request().Context(), func(ctx context.Context, tx *edgedb.Tx) error {
+		return models.SessionRevoke(ctx, tx, data.SessionID)
+	})
+	if errors.Is(err, models.ErrSessionNotFound) {
+		return newEchoHTTPError(http.StatusNotFound, "session does not exist", err)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to revoke session: %w", err)
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
@@ -4,12 +4,20 @@ import (
 	"context"
 	"fmt"
 	"net/http"
+	"time"
 	"world-sounds/models"
+	"world-sounds/services"
 
 	"github.com/edgedb/edgedb-go"
 	"github.com/labstack/echo/v4"
 )
 
+// How long past its air window a stream's audio URL stays valid, so listeners who tuned in late can finish playback
+const streamPlaybackGrace = time.Minute
+
+// Owners can play their own bids and streams at any time, through short-lived URLs
+const ownerAudioURLExpiry = 15 * time.Minute
+
 func (h *Handler) StreamFetch(c echo.Context) error {
 	authToken, err := GetAuthToken(c)
 	if err != nil {
@@ -28,6 +36,13 @@ func (h *Handler) StreamFetch(c echo.Context) error {
 		return err
 	}
 
+	for i := range result {
+		result[i].AudioURI, err = h.signAudio(c.Request().Context(), result[i].AudioKey, result[i].Renditions, ownerAudioURLExpiry)
+		if err != nil {
+			return err
+		}
+	}
+
 	return c.JSON(http.StatusOK, result)
 }
 
@@ -64,5 +79,39 @@ func (h *Handler) StreamLatestFetch(c echo.Context) error {
 		return fmt.Errorf("failed to fetch latest stream: %w", err)
 	}
 
+	// Audio is only playable while the stream airs, streams that already aired are returned without audio
+	for i := range result {
+		result[i].WaveformURI = h.waveformURI(result[i].WaveformKey)
+		result[i].User.ImageURI = h.imageURIs(result[i].User.ImageKeys)
+
+		expiry := time.Until(result[i].AirEnd()) + streamPlaybackGrace
+		if expiry < time.Second {
+			continue
+		}
+
+		audioURI, err := h.signAudio(c.Request().Context(), result[i].AudioKey, result[i].Renditions, expiry)
+		if err != nil {
+			return err
+		}
+		result[i].AudioURI = &audioURI
+	}
+
 	return c.JSON(http.StatusOK, result)
 }
+
+// Signs the private audio and every rendition, filling in the rendition URIs
+func (h *Handler) signAudio(ctx context.Context, audioKey string, renditions []models.AudioRendition, expiry time.Duration) (string, error) {
+	audioURI, err := h.Blobs.SignedURL(ctx, services.BucketPrivateAudio, audioKey, expiry)
+	if err != nil {
+		return "", fmt.Errorf("failed to sign audio: %w", err)
+	}
+
+	for i := range renditions {
+		renditions[i].URI, err = h.Blobs.SignedURL(ctx, services.BucketPrivateAudio, renditions[i].Key, expiry)
+		if err != nil {
+			return "", fmt.Errorf("failed to sign %s rendition: %w", renditions[i].Codec, err)
+		}
+	}
+
+	return audioURI, nil
+}
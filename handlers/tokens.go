@@ -0,0 +1,192 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+	"world-sounds/models"
+
+	"github.com/edgedb/edgedb-go"
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	ScopeRead         = "read"
+	ScopeBidsWrite    = "bids:write"
+	ScopeProfileWrite = "profile:write"
+)
+
+// Makes access tokens easy to tell apart from EdgeDB auth tokens and to find in leaked secrets
+const AccessTokenPrefix = "wst_"
+
+// Requires access tokens to have the scope, auth tokens from the sign in flow are allowed everything
+func RequireScope(scope string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			authToken, ok := c.Get("authToken").(models.AuthToken)
+			if ok && authToken.Scopes != nil && !slices.Contains(authToken.Scopes, scope) {
+				return newEchoHTTPError(http.StatusForbidden, fmt.Sprintf("Forbidden: access token lacks the %s scope", scope), nil)
+			}
+			return next(c)
+		}
+	}
+}
+
+// Keeps access tokens from managing sessions and minting further access tokens
+func RequireSession(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		authToken, ok := c.Get("authToken").(models.AuthToken)
+		if ok && authToken.ClientToken == "" {
+			return newEchoHTTPError(http.StatusForbidden, "Forbidden: access tokens can't be used here", nil)
+		}
+		return next(c)
+	}
+}
+
+// Returns nil for unknown, revoked and expired access tokens
+func (h *Handler) AccessTokenAuthenticate(ctx context.Context, accessToken string) (*models.AuthToken, error) {
+	if !strings.HasPrefix(accessToken, AccessTokenPrefix) {
+		return nil, nil
+	}
+
+	var result *models.AccessTokenTouchResult
+	err := models.GetTx(h.DB, nil)(ctx, func(ctx context.Context, tx *edgedb.Tx) error {
+		var err error
+		result, err = models.AccessTokenTouch(ctx, tx, hashAuthToken(accessToken))
+		if err != nil {
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to touch access token: %w", err)
+	}
+
+	if result == nil {
+		return nil, nil
+	}
+
+	return &models.AuthToken{
+		UserID: result.UserID,
+		Scopes: result.Scopes,
+	}, nil
+}
+
+type AccessTokensCreateData struct {
+	Name          string   `json:"name" validate:"required,max=100"`
+	Scopes        []string `json:"scopes" validate:"required,min=1,dive,oneof=read bids:write profile:write"`
+	ExpiresInDays int64    `json:"expires_in_days" validate:"omitempty,min=1,max=365"`
+}
+
+type AccessTokensCreateResponse struct {
+	ID        edgedb.UUID             `json:"id"`
+	Name      string                  `json:"name"`
+	Scopes    []string                `json:"scopes"`
+	CreatedAt time.Time               `json:"created_at"`
+	ExpiresAt edgedb.OptionalDateTime `json:"expires_at"`
+	Token     string                  `json:"token"`
+}
+
+// The token is only ever returned here, only its hash is stored
+func (h *Handler) AccessTokensCreate(c echo.Context) error {
+	authToken, err := GetAuthToken(c)
+	if err != nil {
+		return err
+	}
+
+	data, err := validateData[AccessTokensCreateData](c)
+	if err != nil {
+		return err
+	}
+
+	scopes := slices.Clone(data.Scopes)
+	slices.Sort(scopes)
+	scopes = slices.Compact(scopes)
+
+	var expiresAt edgedb.OptionalDateTime
+	if data.ExpiresInDays > 0 {
+		expiresAt = edgedb.NewOptionalDateTime(time.Now().AddDate(0, 0, int(data.ExpiresInDays)))
+	}
+
+	tokenBytes := make([]byte, 32)
+	if _, err := rand.Read(tokenBytes); err != nil {
+		return fmt.Errorf("failed to read random bytes: %w", err)
+	}
+	token := AccessTokenPrefix + hex.EncodeToString(tokenBytes)
+
+	var accessToken *models.AccessTokenCreateResult
+	err = models.GetTx(h.DB, authToken)(c.Request().Context(), func(ctx context.Context, tx *edgedb.Tx) error {
+		accessToken, err = models.AccessTokenCreate(ctx, tx, hashAuthToken(token), data.Name, scopes, expiresAt)
+		if err != nil {
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create access token: %w", err)
+	}
+
+	return c.JSON(http.StatusCreated, AccessTokensCreateResponse{
+		ID:        accessToken.ID,
+		Name:      data.Name,
+		Scopes:    scopes,
+		CreatedAt: accessToken.CreatedAt,
+		ExpiresAt: expiresAt,
+		Token:     token,
+	})
+}
+
+func (h *Handler) AccessTokensFetch(c echo.Context) error {
+	authToken, err := GetAuthToken(c)
+	if err != nil {
+		return err
+	}
+
+	var accessTokens []models.AccessTokensFetchResult
+	err = models.GetTx(h.DB, authToken)(c.Request().Context(), func(ctx context.Context, tx *edgedb.Tx) error {
+		accessTokens, err = models.AccessTokensFetch(ctx, tx)
+		if err != nil {
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to fetch access tokens: %w", err)
+	}
+
+	return c.JSON(http.StatusOK, accessTokens)
+}
+
+type AccessTokensDeleteData struct {
+	AccessTokenID edgedb.UUID `param:"id" validate:"required"`
+}
+
+func (h *Handler) AccessTokensDelete(c echo.Context) error {
+	authToken, err := GetAuthToken(c)
+	if err != nil {
+		return err
+	}
+
+	data, err := validateData[AccessTokensDeleteData](c)
+	if err != nil {
+		return err
+	}
+
+	err = models.GetTx(h.DB, authToken)(c.Request().Context(), func(ctx context.Context, tx *edgedb.Tx) error {
+		return models.AccessTokenRevoke(ctx, tx, data.AccessTokenID)
+	})
+	if errors.Is(err, models.ErrAccessTokenNotFound) {
+		return newEchoHTTPError(http.StatusNotFound, "access token does not exist", err)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to revoke access token: %w", err)
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
@@ -0,0 +1,360 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+	"world-sounds/models"
+	"world-sounds/services"
+
+	"github.com/edgedb/edgedb-go"
+	"github.com/labstack/echo/v4"
+)
+
+// S3 rejects multipart parts smaller than 5 MiB, except for the last one
+const minUploadChunkSize = 5 << 20
+
+// How long an append may take to store its part before another append can claim the same part
+const uploadClaimDuration = 5 * time.Minute
+
+type UploadsCreateData struct {
+	Size int64 `json:"size" validate:"required,min=1"`
+}
+
+func (h *Handler) UploadPolicyFetch(c echo.Context) error {
+	return c.JSON(http.StatusOK, h.UploadPolicy)
+}
+
+func (h *Handler) UploadsCreate(c echo.Context) error {
+	authToken, err := GetAuthToken(c)
+	if err != nil {
+		return err
+	}
+
+	data, err := validateData[UploadsCreateData](c)
+	if err != nil {
+		return err
+	}
+
+	if data.Size > h.UploadPolicy.MaxSizeBytes {
+		return newEchoHTTPError(http.StatusRequestEntityTooLarge, fmt.Sprintf("size must be less than or equal to %d bytes", h.UploadPolicy.MaxSizeBytes), nil)
+	}
+
+	objectName, err := randomObjectName()
+	if err != nil {
+		return err
+	}
+
+	remoteUploadID, err := h.Blobs.CreateMultipart(c.Request().Context(), objectName)
+	if err != nil {
+		return err
+	}
+
+	var uploadID string
+	err = models.GetTx(h.DB, authToken)(c.Request().Context(), func(ctx context.Context, tx *edgedb.Tx) error {
+		uploadID, err = models.UploadCreate(ctx, tx, remoteUploadID, objectName, data.Size, time.Now().Add(h.UploadTTL))
+		if err != nil {
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create upload: %w", err)
+	}
+
+	c.Response().Header().Set(echo.HeaderLocation, fmt.Sprintf("%s/%s", c.Request().URL.Path, uploadID))
+	return c.JSON(http.StatusCreated, map[string]any{"id": uploadID})
+}
+
+func (h *Handler) UploadsStatus(c echo.Context) error {
+	authToken, err := GetAuthToken(c)
+	if err != nil {
+		return err
+	}
+
+	upload, err := h.fetchUpload(c, authToken)
+	if err != nil {
+		return err
+	}
+
+	c.Response().Header().Set("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+	c.Response().Header().Set("Upload-Length", strconv.FormatInt(upload.Size, 10))
+	c.Response().Header().Set(echo.HeaderCacheControl, "no-store")
+
+	return c.JSON(http.StatusOK, upload)
+}
+
+func (h *Handler) UploadsAppend(c echo.Context) error {
+	authToken, err := GetAuthToken(c)
+	if err != nil {
+		return err
+	}
+
+	upload, err := h.fetchUpload(c, authToken)
+	if err != nil {
+		return err
+	}
+
+	offset, err := strconv.ParseInt(c.Request().Header.Get("Upload-Offset"), 10, 64)
+	if err != nil {
+		return newEchoHTTPError(http.StatusBadRequest, "Upload-Offset header must be an integer", err)
+	}
+
+	if offset != upload.Offset {
+		return newEchoHTTPError(http.StatusConflict, fmt.Sprintf("Upload-Offset must be %d", upload.Offset), nil)
+	}
+
+	remaining := upload.Size - upload.Offset
+	chunk, err := io.ReadAll(io.LimitReader(c.Request().Body, remaining+1))
+	if err != nil {
+		return fmt.Errorf("failed to read chunk: %w", err)
+	}
+
+	chunkSize := int64(len(chunk))
+	if chunkSize == 0 {
+		return newEchoHTTPError(http.StatusBadRequest, "chunk must not be empty", nil)
+	}
+
+	if chunkSize > remaining {
+		return newEchoHTTPError(http.StatusRequestEntityTooLarge, fmt.Sprintf("chunk must be less than or equal to %d bytes", remaining), nil)
+	}
+
+	if chunkSize < remaining && chunkSize < minUploadChunkSize {
+		return newEchoHTTPError(http.StatusBadRequest, fmt.Sprintf("chunk must be at least %d bytes unless it is the last one", minUploadChunkSize), nil)
+	}
+
+	// The part is claimed before it's stored, as concurrent appends at the same offset would overwrite each other's part
+	claimExpiresAt := time.Now().Add(uploadClaimDuration)
+	var claim *models.UploadClaimResult
+	err = models.GetTx(h.DB, authToken)(c.Request().Context(), func(ctx context.Context, tx *edgedb.Tx) error {
+		claim, err = models.UploadClaim(ctx, tx, upload.ID, offset, claimExpiresAt)
+		if err != nil {
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to claim upload part: %w", err)
+	}
+	if claim == nil {
+		return newEchoHTTPError(http.StatusNotFound, "upload does not exist", nil)
+	}
+
+	appendToken, ok := claim.AppendToken.Get()
+	if !ok {
+		if claim.Offset != offset {
+			return newEchoHTTPError(http.StatusConflict, fmt.Sprintf("Upload-Offset must be %d", claim.Offset), nil)
+		}
+		return newEchoHTTPError(http.StatusConflict, fmt.Sprintf("a chunk is already being appended at offset %d", offset), nil)
+	}
+
+	// Storing the part must not outlast the claim, or another append could write the same part
+	putCtx, cancel := context.WithDeadline(c.Request().Context(), claimExpiresAt)
+	defer cancel()
+	err = h.Blobs.PutPart(putCtx, upload.ObjectName, upload.RemoteUploadID, int(claim.PartCount+1), bytes.NewReader(chunk), chunkSize)
+	if err != nil {
+		releaseErr := models.GetTx(h.DB, authToken)(c.Request().Context(), func(ctx context.Context, tx *edgedb.Tx) error {
+			return models.UploadRelease(ctx, tx, upload.ID, appendToken)
+		})
+		if releaseErr != nil {
+			slog.Error("Failed to release upload claim", slog.Any("err", releaseErr), slog.String("uploadID", upload.ID.String()))
+		}
+		return err
+	}
+
+	err = models.GetTx(h.DB, authToken)(c.Request().Context(), func(ctx context.Context, tx *edgedb.Tx) error {
+		return models.UploadAdvance(ctx, tx, upload.ID, appendToken, chunkSize, time.Now().Add(h.UploadTTL))
+	})
+	if err != nil {
+		return fmt.Errorf("failed to advance upload: %w", err)
+	}
+
+	c.Response().Header().Set("Upload-Offset", strconv.FormatInt(upload.Offset+chunkSize, 10))
+	return c.NoContent(http.StatusNoContent)
+}
+
+type UploadsFinalizeData struct {
+	Credits int64 `json:"credits" form:"credits" validate:"required,min=1"`
+}
+
+func (h *Handler) UploadsFinalize(c echo.Context) error {
+	authToken, err := GetAuthToken(c)
+	if err != nil {
+		return err
+	}
+
+	upload, err := h.fetchUpload(c, authToken)
+	if err != nil {
+		return err
+	}
+
+	data, err := validateData[UploadsFinalizeData](c)
+	if err != nil {
+		return err
+	}
+
+	if upload.Offset != upload.Size {
+		return newEchoHTTPError(http.StatusConflict, fmt.Sprintf("upload is incomplete: %d of %d bytes received", upload.Offset, upload.Size), nil)
+	}
+
+	err = h.checkUserCredits(c.Request().Context(), authToken, data.Credits)
+	if err != nil {
+		return err
+	}
+
+	// The completed upload is kept until a bid is placed from it, so a rejected bid can be retried without uploading
+	// the file again
+	if !upload.Completed {
+		err = h.Blobs.CompleteMultipart(c.Request().Context(), upload.ObjectName, upload.RemoteUploadID)
+		if err != nil {
+			return err
+		}
+
+		err = models.GetTx(h.DB, authToken)(c.Request().Context(), func(ctx context.Context, tx *edgedb.Tx) error {
+			return models.UploadComplete(ctx, tx, upload.ID, time.Now().Add(h.UploadTTL))
+		})
+		if err != nil {
+			return fmt.Errorf("failed to complete upload: %w", err)
+		}
+		upload.Completed = true
+	}
+
+	src, err := h.Blobs.Get(c.Request().Context(), services.BucketUpload, upload.ObjectName)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	bidID, err := h.createBid(c.Request().Context(), authToken, data.Credits, src)
+	if err != nil {
+		return err
+	}
+
+	err = h.removeUpload(c.Request().Context(), authToken, upload)
+	if err != nil {
+		slog.Error("Failed to remove finalized upload", slog.Any("err", err), slog.String("uploadID", upload.ID.String()))
+	}
+
+	return c.JSON(http.StatusCreated, map[string]any{"id": bidID})
+}
+
+func (h *Handler) UploadsDelete(c echo.Context) error {
+	authToken, err := GetAuthToken(c)
+	if err != nil {
+		return err
+	}
+
+	upload, err := h.fetchUpload(c, authToken)
+	if err != nil {
+		return err
+	}
+
+	err = h.removeUpload(c.Request().Context(), authToken, upload)
+	if err != nil {
+		return err
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+// Removes the parts or object of uploads that expired before a bid was placed from them. An upload is only deleted once
+// they are gone, so a failed removal is retried on the next run
+func (h *Handler) UploadsCleanup(ctx context.Context) (int, error) {
+	removed := 0
+	for {
+		var uploads []models.UploadsExpiredFetchResult
+		err := models.GetTx(h.DB, nil)(ctx, func(ctx context.Context, tx *edgedb.Tx) error {
+			var err error
+			uploads, err = models.UploadsExpiredFetch(ctx, tx, 100)
+			if err != nil {
+				return err
+			}
+			return nil
+		})
+		if err != nil {
+			return removed, fmt.Errorf("failed to fetch expired uploads: %w", err)
+		}
+
+		if len(uploads) == 0 {
+			return removed, nil
+		}
+
+		var removedIDs []edgedb.UUID
+		for _, upload := range uploads {
+			var err error
+			if upload.Completed {
+				err = h.Blobs.Delete(ctx, services.BucketUpload, upload.ObjectName)
+			} else {
+				err = h.Blobs.AbortMultipart(ctx, upload.ObjectName, upload.RemoteUploadID)
+			}
+			if err != nil {
+				slog.Error("Failed to remove expired upload", slog.Any("err", err), slog.String("objectName", upload.ObjectName))
+				continue
+			}
+			removedIDs = append(removedIDs, upload.ID)
+		}
+
+		if len(removedIDs) == 0 {
+			return removed, fmt.Errorf("failed to remove any of %d expired uploads", len(uploads))
+		}
+
+		err = models.GetTx(h.DB, nil)(ctx, func(ctx context.Context, tx *edgedb.Tx) error {
+			return models.UploadsDeleteByIDs(ctx, tx, removedIDs)
+		})
+		if err != nil {
+			return removed, fmt.Errorf("failed to delete expired uploads: %w", err)
+		}
+		removed += len(removedIDs)
+
+		if len(removedIDs) < len(uploads) {
+			// Leave the failed ones for the next run instead of fetching them again right away
+			return removed, nil
+		}
+	}
+}
+
+func (h *Handler) fetchUpload(c echo.Context, authToken *models.AuthToken) (*models.UploadFetchResult, error) {
+	uploadID, err := edgedb.ParseUUID(c.Param("id"))
+	if err != nil {
+		return nil, newEchoHTTPError(http.StatusBadRequest, fmt.Sprintf("invalid id: %s", c.Param("id")), err)
+	}
+
+	var upload *models.UploadFetchResult
+	err = models.GetTx(h.DB, authToken)(c.Request().Context(), func(ctx context.Context, tx *edgedb.Tx) error {
+		upload, err = models.UploadFetch(ctx, tx, uploadID)
+		if err != nil {
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch upload: %w", err)
+	}
+	if upload == nil {
+		return nil, newEchoHTTPError(http.StatusNotFound, "upload does not exist", nil)
+	}
+
+	return upload, nil
+}
+
+// Completed uploads only have their object left, the others have their parts aborted
+func (h *Handler) removeUpload(ctx context.Context, authToken *models.AuthToken, upload *models.UploadFetchResult) error {
+	var err error
+	if upload.Completed {
+		err = h.Blobs.Delete(ctx, services.BucketUpload, upload.ObjectName)
+	} else {
+		err = h.Blobs.AbortMultipart(ctx, upload.ObjectName, upload.RemoteUploadID)
+	}
+	if err != nil {
+		slog.Error("Failed to remove upload object", slog.Any("err", err), slog.String("objectName", upload.ObjectName))
+	}
+
+	return models.GetTx(h.DB, authToken)(ctx, func(ctx context.Context, tx *edgedb.Tx) error {
+		return models.UploadDelete(ctx, tx, upload.ID)
+	})
+}
@@ -1,10 +1,15 @@
 package handlers
 
 import (
+	"bytes"
 	"context"
+	"crypto/sha256"
+	"errors"
 	"fmt"
+	"math"
 	"net/http"
-	"os"
+	"strconv"
+	"time"
 	"world-sounds/models"
 	"world-sounds/services"
 
@@ -32,6 +37,7 @@ func (h *Handler) UserFetch(c echo.Context) error {
 	if user == nil {
 		return c.JSON(http.StatusNotFound, http.StatusText(http.StatusNotFound))
 	}
+	user.ImageURI = h.imageURIs(user.ImageKeys)
 
 	return c.JSON(http.StatusOK, user)
 }
@@ -40,6 +46,8 @@ type UserUpdateData struct {
 	Username *string `json:"username"`
 }
 
+// Usernames are checked against the username policy and can only be changed once per cooldown. A taken username
+// is answered with a free generated one the user can pick instead
 func (h *Handler) UserUpdate(c echo.Context) error {
 	authToken, err := GetAuthToken(c)
 	if err != nil {
@@ -52,6 +60,36 @@ func (h *Handler) UserUpdate(c echo.Context) error {
 	}
 
 	err = models.GetTx(h.DB, authToken)(c.Request().Context(), func(ctx context.Context, tx *edgedb.Tx) error {
+		// An unchanged username is left alone, so names from before a policy change can still be resubmitted
+		if data.Username != nil {
+			user, err := models.UserFetch(ctx, tx)
+			if err != nil {
+				return err
+			}
+			if user == nil {
+				return models.ErrUserNotFound
+			}
+
+			if user.Username != *data.Username {
+				violations := h.UsernamePolicy.Check(*data.Username)
+				if len(violations) > 0 {
+					return newEchoHTTPError(http.StatusUnprocessableEntity, map[string]any{
+						"message":    "Username violates the username policy",
+						"violations": violations,
+					}, nil)
+				}
+			}
+
+			usernameChangedAt, ok := user.UsernameChangedAt.Get()
+			if ok && user.Username != *data.Username {
+				changeableAt := usernameChangedAt.Add(h.UsernameCooldown)
+				if time.Now().Before(changeableAt) {
+					c.Response().Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(time.Until(changeableAt).Seconds()))))
+					return newEchoHTTPError(http.StatusTooManyRequests, fmt.Sprintf("username can be changed again at %s", changeableAt.UTC().Format(time.RFC3339)), nil)
+				}
+			}
+		}
+
 		err = models.UserUpdate(ctx, tx, data.Username)
 		if err != nil {
 			return err
@@ -59,6 +97,17 @@ func (h *Handler) UserUpdate(c echo.Context) error {
 
 		return nil
 	})
+	var httpError *echo.HTTPError
+	if errors.As(err, &httpError) {
+		return httpError
+	}
+	if errors.Is(err, models.ErrUserNotFound) {
+		return newEchoHTTPError(http.StatusNotFound, "user does not exist", err)
+	}
+	var edgedbErr edgedb.Error
+	if errors.As(err, &edgedbErr) && edgedbErr.Category(edgedb.ConstraintViolationError) {
+		return h.usernameTakenError(c.Request().Context(), authToken, err)
+	}
 	if err != nil {
 		return fmt.Errorf("failed to update user: %w", err)
 	}
@@ -66,6 +115,28 @@ func (h *Handler) UserUpdate(c echo.Context) error {
 	return c.NoContent(http.StatusNoContent)
 }
 
+// The only constraint a username change can violate is its uniqueness
+func (h *Handler) usernameTakenError(ctx context.Context, authToken *models.AuthToken, err error) error {
+	var suggestion string
+	suggestErr := models.GetTx(h.DB, authToken)(ctx, func(ctx context.Context, tx *edgedb.Tx) error {
+		var err error
+		suggestion, err = models.UsernameSuggest(ctx, tx)
+		if err != nil {
+			return err
+		}
+		return nil
+	})
+	if suggestErr != nil {
+		return fmt.Errorf("failed to suggest username: %w", suggestErr)
+	}
+
+	response := map[string]any{"message": "username is already taken"}
+	if suggestion != "" && len(h.UsernamePolicy.Check(suggestion)) == 0 {
+		response["suggestion"] = suggestion
+	}
+	return newEchoHTTPError(http.StatusConflict, response, err)
+}
+
 func (h *Handler) UserUpdateImage(c echo.Context) error {
 	authToken, err := GetAuthToken(c)
 	if err != nil {
@@ -82,24 +153,36 @@ func (h *Handler) UserUpdateImage(c echo.Context) error {
 	}
 	defer src.Close()
 
-	filePath, err := services.ProcessImage(c.Request().Context(), src)
+	image, err := h.Image.ProcessImage(c.Request().Context(), src, services.AvatarSizes)
+	if errors.Is(err, services.ErrImageTooLarge) {
+		return newEchoHTTPError(http.StatusRequestEntityTooLarge, "image is too large", err)
+	}
+	if errors.Is(err, services.ErrImageUnsupported) {
+		return newEchoHTTPError(http.StatusUnsupportedMediaType, "unsupported image format", err)
+	}
 	if err != nil {
 		return fmt.Errorf("failed to process image: %w", err)
 	}
-	defer os.Remove(filePath)
+	defer image.Remove()
 
-	imageHash, err := SHA256File(filePath)
+	// Every size shares the hash of the largest one, so a given upload always maps to the same objects
+	imageHash, err := SHA256File(image.Sizes[len(image.Sizes)-1].FilePath)
 	if err != nil {
 		return fmt.Errorf("failed to hash image file: %w", err)
 	}
 
-	fileLocation, err := h.S3.UploadWebP(c.Request().Context(), filePath, imageHash+".webp")
-	if err != nil {
-		return fmt.Errorf("failed to upload WebP: %w", err)
+	imageKeys := models.ImageKeys{}
+	for _, size := range image.Sizes {
+		objectName := fmt.Sprintf("%s-%d.%s", imageHash, size.Size, image.Extension)
+		err := services.PutFile(c.Request().Context(), h.Blobs, services.BucketImage, objectName, size.FilePath, image.ContentType)
+		if err != nil {
+			return fmt.Errorf("failed to upload image: %w", err)
+		}
+		imageKeys[strconv.Itoa(size.Size)] = objectName
 	}
 
 	err = models.GetTx(h.DB, authToken)(c.Request().Context(), func(ctx context.Context, tx *edgedb.Tx) error {
-		err = models.UserUpdateImage(ctx, tx, fileLocation)
+		err = models.UserUpdateImage(ctx, tx, imageKeys)
 		if err != nil {
 			return err
 		}
@@ -110,5 +193,60 @@ func (h *Handler) UserUpdateImage(c echo.Context) error {
 		return fmt.Errorf("failed to update image: %w", err)
 	}
 
-	return c.JSON(http.StatusCreated, map[string]any{"image_uri": fileLocation})
+	return c.JSON(http.StatusCreated, map[string]any{"image_uri": h.imageURIs(imageKeys)})
+}
+
+func (h *Handler) UserResetImage(c echo.Context) error {
+	authToken, err := GetAuthToken(c)
+	if err != nil {
+		return err
+	}
+
+	var user *models.UserFetchResult
+	err = models.GetTx(h.DB, authToken)(c.Request().Context(), func(ctx context.Context, tx *edgedb.Tx) error {
+		user, err = models.UserFetch(ctx, tx)
+		if err != nil {
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to fetch user: %w", err)
+	}
+	if user == nil {
+		return newEchoHTTPError(http.StatusNotFound, "user does not exist", nil)
+	}
+
+	imageKeys, err := h.setGeneratedImage(c.Request().Context(), authToken, user.ID.String())
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, map[string]any{"image_uri": h.imageURIs(imageKeys)})
+}
+
+// Uploads the identicon generated from the user ID and sets it for every avatar size. The object name is the hash of
+// the image, so resetting again overwrites the same object
+func (h *Handler) setGeneratedImage(ctx context.Context, authToken *models.AuthToken, userID string) (models.ImageKeys, error) {
+	identicon := services.Identicon([]byte(userID))
+
+	objectName := fmt.Sprintf("%x.svg", sha256.Sum256(identicon))
+	err := h.Blobs.Put(ctx, services.BucketImage, objectName, bytes.NewReader(identicon), int64(len(identicon)), "image/svg+xml")
+	if err != nil {
+		return nil, fmt.Errorf("failed to upload generated image: %w", err)
+	}
+
+	imageKeys := models.ImageKeys{}
+	for _, size := range services.AvatarSizes {
+		imageKeys[strconv.Itoa(size)] = objectName
+	}
+
+	err = models.GetTx(h.DB, authToken)(ctx, func(ctx context.Context, tx *edgedb.Tx) error {
+		return models.UserUpdateImage(ctx, tx, imageKeys)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to update image: %w", err)
+	}
+
+	return imageKeys, nil
 }
@@ -0,0 +1,73 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"path"
+	"strconv"
+	"testing"
+	"world-sounds/models"
+	"world-sounds/services"
+
+	"github.com/labstack/echo/v4"
+)
+
+func TestUserUpdateImageRejectsInvalidImages(t *testing.T) {
+	h := testHandler(t)
+	authToken := models.AuthToken{ClientToken: "client-token"}
+
+	tests := []struct {
+		name     string
+		files    map[string][]byte
+		wantCode int
+	}{
+		{"missing image", nil, http.StatusBadRequest},
+		{"not an image", map[string][]byte{"image": []byte("definitely not an image")}, http.StatusUnsupportedMediaType},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := testMultipartRequest(t, http.MethodPatch, "/api/v1/me/image", nil, test.files)
+			if code, _ := testServe(t, h.UserUpdateImage, req, &authToken); code != test.wantCode {
+				t.Errorf("got %d, want %d", code, test.wantCode)
+			}
+		})
+	}
+}
+
+func TestUserUpdateImage(t *testing.T) {
+	h := testHandler(t)
+	testDB(t, h)
+	authToken := testUser(t, h, 0)
+
+	req := testMultipartRequest(t, http.MethodPatch, "/api/v1/me/image", nil, map[string][]byte{"image": testPNG(t, 600, 400)})
+	code, rec := testServe(t, h.UserUpdateImage, req, &authToken)
+	if code != http.StatusCreated {
+		t.Fatalf("got %d, want %d: %s", code, http.StatusCreated, rec.Body)
+	}
+
+	var response struct {
+		ImageURI map[string]string `json:"image_uri"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, size := range services.AvatarSizes {
+		imageURI, ok := response.ImageURI[strconv.Itoa(size)]
+		if !ok {
+			t.Errorf("response has no %d pixel image", size)
+			continue
+		}
+
+		if _, err := h.Blobs.Stat(context.Background(), services.BucketImage, path.Base(imageURI)); err != nil {
+			t.Errorf("%d pixel image isn't stored: %v", size, err)
+		}
+
+		rec := testFetchBlob(t, h, imageURI)
+		if rec.Code != http.StatusOK || rec.Header().Get(echo.HeaderContentType) != "image/png" {
+			t.Errorf("%d pixel image URL got %d with %s, want a PNG file", size, rec.Code, rec.Header().Get(echo.HeaderContentType))
+		}
+	}
+}
@@ -3,15 +3,18 @@ package main
 import (
 	"context"
 	"errors"
+	"expvar"
 	"fmt"
 	"log/slog"
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"sync"
 	"time"
 	"world-sounds/handlers"
 	"world-sounds/models"
+	"world-sounds/services"
 
 	"github.com/edgedb/edgedb-go"
 	"github.com/go-playground/validator/v10"
@@ -36,6 +39,8 @@ func (cv *CustomValidator) Validate(i interface{}) error {
 }
 
 func main() {
+	services.RunFFmpegShim()
+
 	slog.SetDefault(slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{
 		Level: slog.LevelDebug,
 	})))
@@ -63,8 +68,10 @@ func main() {
 		var sendError error
 		if c.Request().Method == http.MethodHead {
 			sendError = c.NoContent(httpError.Code)
+		} else if message, ok := httpError.Message.(string); ok {
+			sendError = c.String(httpError.Code, message)
 		} else {
-			sendError = c.String(httpError.Code, fmt.Sprint(httpError.Message))
+			sendError = c.JSON(httpError.Code, httpError.Message)
 		}
 
 		if sendError != nil {
@@ -127,18 +134,52 @@ func main() {
 
 	e.Use(middleware.Secure())
 
-	e.Use(middleware.CORS())
+	// Browsers keep cross origin requests from reading responses unless the origin is allowed
+	if len(handler.AllowedOrigins) > 0 {
+		e.Use(middleware.CORSWithConfig(middleware.CORSConfig{
+			AllowOrigins:     handler.AllowedOrigins,
+			AllowCredentials: true,
+		}))
+	}
 
 	e.Use(middleware.BodyLimit("60MB"))
 
 	e.Use(func(next echo.HandlerFunc) echo.HandlerFunc {
 		return func(c echo.Context) error {
-			cookie, err := c.Cookie("edgedb-auth-token")
+			if authorization := c.Request().Header.Get(echo.HeaderAuthorization); authorization != "" {
+				accessToken, ok := strings.CutPrefix(authorization, "Bearer ")
+				if !ok {
+					return echo.NewHTTPError(http.StatusUnauthorized, "Unauthorized: expected a bearer token")
+				}
+
+				authToken, err := handler.AccessTokenAuthenticate(c.Request().Context(), accessToken)
+				if err != nil {
+					return err
+				}
+				if authToken == nil {
+					return echo.NewHTTPError(http.StatusUnauthorized, "Unauthorized: invalid access token")
+				}
+
+				c.Set("authToken", *authToken)
+
+				return next(c)
+			}
+
+			cookie, err := c.Cookie(handlers.AuthTokenCookieName)
 			if err != nil {
 				return next(c)
 			}
 
-			c.Set("authToken", cookie.Value)
+			revoked, err := handler.SessionTouch(c.Request().Context(), cookie.Value, c.RealIP())
+			if err != nil {
+				return err
+			}
+			if revoked {
+				handlers.ClearAuthTokenCookie(c)
+				return echo.NewHTTPError(http.StatusUnauthorized, "Unauthorized: session has been revoked or expired")
+			}
+
+			c.Set("authToken", models.AuthToken{ClientToken: cookie.Value})
 
 			// TODO: add user identity to the context for logging
 
@@ -146,10 +187,16 @@ func main() {
 		}
 	})
 
+	e.Use(handler.CheckOrigin)
+
 	e.GET("", func(c echo.Context) error {
 		return c.HTML(http.StatusOK, indexHTML)
 	})
 
+	if localBlobStore, ok := handler.Blobs.(*services.LocalBlobStore); ok {
+		handlers.LocalBlobRoutes(e, localBlobStore)
+	}
+
 	api := e.Group("/api")
 
 	v1 := api.Group("/v1")
@@ -157,26 +204,73 @@ func main() {
 	auth := v1.Group("/auth")
 	auth.GET("/signin", handler.AuthSignIn)
 	auth.GET("/callback", handler.AuthCallback)
+	auth.POST("/signout", handler.AuthSignOut)
+
+	read := handlers.RequireScope(handlers.ScopeRead)
+	bidsWrite := handlers.RequireScope(handlers.ScopeBidsWrite)
+	profileWrite := handlers.RequireScope(handlers.ScopeProfileWrite)
 
 	me := v1.Group("/me")
-	me.GET("", handler.UserFetch)
-	me.PATCH("", handler.UserUpdate)
-	me.PATCH("/image", handler.UserUpdateImage)
-	me.GET("/deposits", handler.DepositsFetch)
-	me.GET("/bids", handler.BidsFetch)
-	me.GET("/stream", handler.StreamFetch)
+	me.GET("", handler.UserFetch, read)
+	me.PATCH("", handler.UserUpdate, profileWrite, handler.RequireActiveAccount)
+	me.PATCH("/image", handler.UserUpdateImage, profileWrite, handler.RequireActiveAccount)
+	me.DELETE("/image", handler.UserResetImage, profileWrite)
+	me.GET("/deposits", handler.DepositsFetch, read)
+	me.GET("/bids", handler.BidsFetch, read)
+	me.GET("/stream", handler.StreamFetch, read)
+	me.GET("/sessions", handler.SessionsFetch, handlers.RequireSession)
+	me.DELETE("/sessions/:id", handler.SessionsDelete, handlers.RequireSession)
+	me.GET("/tokens", handler.AccessTokensFetch, handlers.RequireSession)
+	me.POST("/tokens", handler.AccessTokensCreate, handlers.RequireSession)
+	me.DELETE("/tokens/:id", handler.AccessTokensDelete, handlers.RequireSession)
+	me.POST("/drafts", handler.DraftsCreate, bidsWrite, handler.RequireActiveAccount)
+	me.GET("/drafts/:id", handler.DraftsFetch, read)
+	me.DELETE("/drafts/:id", handler.DraftsDelete, bidsWrite)
+
+	// Access tokens can't be used for operational tasks, roles are only checked for signed in sessions
+	admin := v1.Group("/admin", handlers.RequireSession, handler.ResolveRoles, handlers.RequireRole(handlers.RoleAdmin, handlers.RoleModerator))
+	admin.GET("/users", handler.AdminUsersFetch)
+	admin.PUT("/users/:id/status", handler.AdminUserUpdateStatus)
+	admin.PUT("/users/:id/roles", handler.AdminUserUpdateRoles, handlers.RequireRole(handlers.RoleAdmin))
+	admin.POST("/users/:id/credits", handler.AdminUserAdjustCredits, handlers.RequireRole(handlers.RoleAdmin))
+	admin.POST("/stream/skip", handler.AdminStreamSkip)
+	admin.DELETE("/bids/:id", handler.AdminBidsDelete)
+	admin.POST("/bids/:id/pin", handler.AdminBidPin, handlers.RequireRole(handlers.RoleAdmin))
+	admin.DELETE("/bids/:id/pin", handler.AdminBidUnpin, handlers.RequireRole(handlers.RoleAdmin))
+	admin.GET("/reports", handler.AdminReportsFetch)
+	admin.POST("/reports/:id/dismiss", handler.AdminReportDismiss)
+	admin.POST("/reports/:id/uphold", handler.AdminReportUphold)
+	admin.GET("/scheduler", handler.AdminSchedulerFetch)
+	admin.POST("/scheduler/pause", handler.AdminSchedulerPause, handlers.RequireRole(handlers.RoleAdmin))
+	admin.POST("/scheduler/resume", handler.AdminSchedulerResume, handlers.RequireRole(handlers.RoleAdmin))
+	admin.GET("/audit-events", handler.AdminAuditEventsFetch, handlers.RequireRole(handlers.RoleAdmin))
+	// Job counters and runtime stats of this replica
+	admin.GET("/debug/vars", echo.WrapHandler(expvar.Handler()), handlers.RequireRole(handlers.RoleAdmin))
 
 	deposits := v1.Group("/deposits")
 	deposits.POST("/webhook", handler.DepositsWebhook)
 
 	bids := v1.Group("/bids")
 	bids.GET("/top", handler.BidsTopFetch)
-	bids.POST("", handler.BidsCreate)
-	bids.DELETE("/:id", handler.BidsDelete)
+	bids.POST("", handler.BidsCreate, bidsWrite, handler.RequireActiveAccount)
+	bids.DELETE("/:id", handler.BidsDelete, bidsWrite)
+
+	v1.GET("/upload-policy", handler.UploadPolicyFetch)
+
+	uploads := v1.Group("/uploads", bidsWrite, handler.RequireActiveAccount)
+	uploads.POST("", handler.UploadsCreate)
+	uploads.GET("/:id", handler.UploadsStatus)
+	uploads.HEAD("/:id", handler.UploadsStatus)
+	uploads.PATCH("/:id", handler.UploadsAppend)
+	uploads.POST("/:id/finalize", handler.UploadsFinalize)
+	uploads.DELETE("/:id", handler.UploadsDelete)
 
 	stream := v1.Group("/stream")
 	stream.GET("/latest", handler.StreamLatestFetch)
 
+	streams := v1.Group("/streams")
+	streams.POST("/:id/reports", handler.ReportsCreate, handlers.RequireSession, handler.RequireActiveAccount)
+
 	go func() {
 		port := os.Getenv("PORT")
 		if port == "" {
@@ -205,23 +299,30 @@ func main() {
 			case <-time.After(timeout):
 				var streamID string
 				err = models.GetTx(handler.DB, nil)(context.Background(), func(ctx context.Context, tx *edgedb.Tx) error {
+					scheduler, err := models.SchedulerStateFetch(ctx, tx)
+					if err != nil {
+						return err
+					}
+
+					if scheduler.Paused {
+						// Skip as operators paused the scheduler, the airing stream still plays to its end
+						return nil
+					}
+
 					stream, err := models.StreamLastestFetch(ctx, tx, 0, 1)
 					if err != nil {
 						return err
 					}
 
 					if len(stream) == 1 {
-						latestStream := stream[0]
-						latestStreamCreatedAt := latestStream.CreatedAt
-						latestStreamAudioDuration := time.Duration(latestStream.AudioDurationSeconds) * time.Second
-						latestStreamEndTime := latestStreamCreatedAt.Add(latestStreamAudioDuration)
+						latestStreamEndTime := stream[0].AirEnd()
 						if time.Until(latestStreamEndTime) > 5*time.Second {
 							// Skip as there are more than 5 seconds left on the current audio
 							return nil
 						}
 					}
 
-					bid, err := models.BidsTopDequeue(ctx, tx)
+					bid, err := handler.BidsTopDequeueEligible(ctx, tx)
 					if err != nil {
 						return err
 					}
@@ -231,7 +332,7 @@ func main() {
 						return nil
 					}
 
-					streamID, err = models.StreamCreate(ctx, tx, bid.AudioURI, bid.AudioDurationSeconds, bid.Credits, bid.UserID)
+					streamID, err = models.StreamCreate(ctx, tx, bid.AudioKey, bid.AudioDurationSeconds, bid.Credits, bid.Fingerprint, bid.WaveformKey, bid.Renditions, bid.UserID)
 					if err != nil {
 						return err
 					}
@@ -252,12 +353,86 @@ func main() {
 
 				slog.Info("Inserted top bid into stream", slog.String("streamID", streamID))
 
+			case <-handler.SchedulerWake:
+				// Run right away, the airing stream was skipped or the scheduler resumed
+				timeout = 0
+
 			case <-shutdownChannel:
 				break loop
 			}
 		}
 	}()
 
+	shutdownWaitGroup.Add(1)
+	go func() {
+		defer shutdownWaitGroup.Done()
+
+		for {
+			select {
+			case <-time.After(1 * time.Minute):
+				removed, err := handler.DraftsCleanup(context.Background())
+				if err != nil {
+					slog.Error("Failed to clean up expired drafts", slog.Any("err", err))
+				}
+				if removed > 0 {
+					slog.Info("Cleaned up expired drafts", slog.Int("removed", removed))
+				}
+
+				removed, err = handler.UploadsCleanup(context.Background())
+				if err != nil {
+					slog.Error("Failed to clean up expired uploads", slog.Any("err", err))
+				}
+				if removed > 0 {
+					slog.Info("Cleaned up expired uploads", slog.Int("removed", removed))
+				}
+
+			case <-shutdownChannel:
+				return
+			}
+		}
+	}()
+
+	shutdownWaitGroup.Add(1)
+	go func() {
+		defer shutdownWaitGroup.Done()
+
+		moved, err := handler.LegacyAudioMove(context.Background())
+		if err != nil {
+			slog.Error("Failed to move legacy audio to the private bucket", slog.Any("err", err))
+		}
+		if moved > 0 {
+			slog.Info("Moved legacy audio to the private bucket", slog.Int("moved", moved))
+		}
+
+		for {
+			select {
+			case <-time.After(1 * time.Hour):
+				report, err := handler.ObjectsCollect(context.Background(), handler.ObjectGCDryRun)
+				if err != nil {
+					slog.Error("Failed to collect unreferenced objects", slog.Any("err", err))
+					continue
+				}
+
+				if report.DryRun {
+					for _, entry := range report.Unreferenced {
+						slog.Info("Unreferenced object", slog.String("bucket", string(entry.Bucket)), slog.String("key", entry.Key), slog.Int64("size", entry.Size), slog.Time("lastModified", entry.LastModified))
+					}
+				}
+
+				slog.Info("Collected unreferenced objects",
+					slog.Bool("dryRun", report.DryRun),
+					slog.Int("scanned", report.Scanned),
+					slog.Int("unreferenced", len(report.Unreferenced)),
+					slog.Int("removed", report.Removed),
+					slog.Int64("removedBytes", report.RemovedBytes),
+				)
+
+			case <-shutdownChannel:
+				return
+			}
+		}
+	}()
+
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, os.Interrupt)
 	<-quit
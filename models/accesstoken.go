@@ -0,0 +1,156 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/edgedb/edgedb-go"
+)
+
+var ErrAccessTokenNotFound = errors.New("access token does not exist")
+
+type AccessTokenCreateResult struct {
+	ID        edgedb.UUID `edgedb:"id"`
+	CreatedAt time.Time   `edgedb:"created_at"`
+}
+
+func AccessTokenCreate(ctx context.Context, tx *edgedb.Tx, tokenHash string, name string, scopes []string, expiresAt edgedb.OptionalDateTime) (*AccessTokenCreateResult, error) {
+	var result AccessTokenCreateResult
+
+	err := tx.QuerySingle(
+		ctx,
+		`SELECT (
+			INSERT AccessToken {
+				token_hash := <str>$token_hash,
+				name := <str>$name,
+				scopes := <array<str>>$scopes,
+				expires_at := <optional datetime>$expires_at,
+				user := global current_user
+			}
+		) {
+			id,
+			created_at
+		}`,
+		&result,
+		map[string]interface{}{
+			"token_hash": tokenHash,
+			"name":       name,
+			"scopes":     scopes,
+			"expires_at": expiresAt,
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+type AccessTokenTouchResult struct {
+	edgedb.Optional
+	UserID edgedb.UUID `edgedb:"user_id"`
+	Scopes []string    `edgedb:"scopes"`
+}
+
+// Records when the token was last used, at most once a minute. Returns nil for unknown, revoked and expired tokens
+func AccessTokenTouch(ctx context.Context, tx *edgedb.Tx, tokenHash string) (*AccessTokenTouchResult, error) {
+	var result AccessTokenTouchResult
+
+	err := tx.QuerySingle(
+		ctx,
+		`WITH
+			access_token := (
+				SELECT AccessToken
+				FILTER .token_hash = <str>$token_hash
+					AND NOT EXISTS .revoked_at
+					AND (NOT EXISTS .expires_at OR .expires_at > datetime_of_statement())
+			),
+			touched := (
+				UPDATE access_token
+				FILTER NOT EXISTS .last_used_at OR .last_used_at < datetime_of_statement() - <duration>'1 minute'
+				SET {
+					last_used_at := datetime_of_statement()
+				}
+			)
+		SELECT access_token {
+			user_id := .user.id,
+			scopes
+		}`,
+		&result,
+		map[string]interface{}{
+			"token_hash": tokenHash,
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+	if result.Missing() {
+		return nil, nil
+	}
+	return &result, nil
+}
+
+type AccessTokensFetchResult struct {
+	ID         edgedb.UUID             `json:"id" edgedb:"id"`
+	Name       string                  `json:"name" edgedb:"name"`
+	Scopes     []string                `json:"scopes" edgedb:"scopes"`
+	CreatedAt  time.Time               `json:"created_at" edgedb:"created_at"`
+	LastUsedAt edgedb.OptionalDateTime `json:"last_used_at" edgedb:"last_used_at"`
+	ExpiresAt  edgedb.OptionalDateTime `json:"expires_at" edgedb:"expires_at"`
+}
+
+func AccessTokensFetch(ctx context.Context, tx *edgedb.Tx) ([]AccessTokensFetchResult, error) {
+	result := []AccessTokensFetchResult{}
+
+	err := tx.Query(
+		ctx,
+		`SELECT AccessToken {
+			id,
+			name,
+			scopes,
+			created_at,
+			last_used_at,
+			expires_at
+		}
+		FILTER .user = global current_user
+			AND NOT EXISTS .revoked_at
+			AND (NOT EXISTS .expires_at OR .expires_at > datetime_of_statement())
+		ORDER BY .created_at DESC`,
+		&result,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+type AccessTokenRevokeResult struct {
+	edgedb.Optional
+	ID edgedb.UUID `edgedb:"id"`
+}
+
+func AccessTokenRevoke(ctx context.Context, tx *edgedb.Tx, accessTokenID edgedb.UUID) error {
+	var result AccessTokenRevokeResult
+
+	err := tx.QuerySingle(
+		ctx,
+		`UPDATE AccessToken
+		FILTER .id = <uuid>$access_token_id
+			AND .user = global current_user
+			AND NOT EXISTS .revoked_at
+		SET {
+			revoked_at := datetime_of_statement()
+		}`,
+		&result,
+		map[string]interface{}{
+			"access_token_id": accessTokenID,
+		},
+	)
+	if err != nil {
+		return err
+	}
+	if result.Missing() {
+		return ErrAccessTokenNotFound
+	}
+	return nil
+}
@@ -0,0 +1,163 @@
+package models
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/edgedb/edgedb-go"
+)
+
+// Target types of audit events
+const (
+	AuditTargetBid       = "Bid"
+	AuditTargetStream    = "Stream"
+	AuditTargetScheduler = "SchedulerState"
+	AuditTargetReport    = "Report"
+	AuditTargetUser      = "User"
+	AuditTargetDeposit   = "Deposit"
+)
+
+// Where the request causing an audit event came from
+type AuditSource struct {
+	IP        string
+	RequestID string
+	// Records the event without an actor, for actions the system takes on its own even when a request triggered them
+	System bool
+}
+
+func optionalJSON(value any) (edgedb.OptionalBytes, error) {
+	var optionalBytes edgedb.OptionalBytes
+	if value == nil {
+		return optionalBytes, nil
+	}
+
+	valueJSON, err := json.Marshal(value)
+	if err != nil {
+		return optionalBytes, err
+	}
+	optionalBytes.Set(valueJSON)
+	return optionalBytes, nil
+}
+
+func optionalStr(value string) edgedb.OptionalStr {
+	var optionalStr edgedb.OptionalStr
+	if value != "" {
+		optionalStr.Set(value)
+	}
+	return optionalStr
+}
+
+// Records an action of the current user, or of the system for system sources, meant to run in the same transaction as
+// the action itself. Before and after are stored as JSON and may be nil
+func AuditEventCreate(ctx context.Context, tx *edgedb.Tx, source AuditSource, action string, targetType string, targetID edgedb.OptionalUUID, reason string, before any, after any) error {
+	beforeJSON, err := optionalJSON(before)
+	if err != nil {
+		return err
+	}
+
+	afterJSON, err := optionalJSON(after)
+	if err != nil {
+		return err
+	}
+
+	return tx.Execute(
+		ctx,
+		`INSERT AuditEvent {
+			action := <str>$action,
+			target_type := <str>$target_type,
+			target_id := <optional uuid>$target_id,
+			reason := <optional str>$reason,
+			before := <optional json>$before,
+			after := <optional json>$after,
+			actor := (SELECT global current_user FILTER NOT <bool>$system),
+			actor_ip := <optional str>$actor_ip,
+			request_id := <optional str>$request_id
+		}`,
+		map[string]interface{}{
+			"action":      action,
+			"target_type": targetType,
+			"target_id":   targetID,
+			"reason":      optionalStr(reason),
+			"before":      beforeJSON,
+			"after":       afterJSON,
+			"system":      source.System,
+			"actor_ip":    optionalStr(source.IP),
+			"request_id":  optionalStr(source.RequestID),
+		},
+	)
+}
+
+type AuditEventsFetchResult struct {
+	ID         edgedb.UUID         `json:"id" edgedb:"id"`
+	Action     string              `json:"action" edgedb:"action"`
+	TargetType string              `json:"target_type" edgedb:"target_type"`
+	TargetID   edgedb.OptionalUUID `json:"target_id" edgedb:"target_id"`
+	Reason     edgedb.OptionalStr  `json:"reason" edgedb:"reason"`
+	Before     json.RawMessage     `json:"before" edgedb:"before"`
+	After      json.RawMessage     `json:"after" edgedb:"after"`
+	Actor      *struct {
+		edgedb.Optional
+		ID       edgedb.UUID `json:"id" edgedb:"id"`
+		Username string      `json:"username" edgedb:"username"`
+	} `json:"actor" edgedb:"actor"`
+	ActorIP   edgedb.OptionalStr `json:"actor_ip" edgedb:"actor_ip"`
+	RequestID edgedb.OptionalStr `json:"request_id" edgedb:"request_id"`
+	CreatedAt time.Time          `json:"created_at" edgedb:"created_at"`
+}
+
+// Lists audit events matching every given filter, newest first
+func AuditEventsFetch(ctx context.Context, tx *edgedb.Tx, action edgedb.OptionalStr, targetType edgedb.OptionalStr, targetID edgedb.OptionalUUID, actorID edgedb.OptionalUUID, since edgedb.OptionalDateTime, until edgedb.OptionalDateTime, offset int64, limit int64) ([]AuditEventsFetchResult, error) {
+	result := []AuditEventsFetchResult{}
+
+	err := tx.Query(
+		ctx,
+		`WITH
+			action := <optional str>$action,
+			target_type := <optional str>$target_type,
+			target_id := <optional uuid>$target_id,
+			actor_id := <optional uuid>$actor_id,
+			since := <optional datetime>$since,
+			until := <optional datetime>$until
+		SELECT AuditEvent {
+			id,
+			action,
+			target_type,
+			target_id,
+			reason,
+			before,
+			after,
+			actor: {
+				id,
+				username
+			},
+			actor_ip,
+			request_id,
+			created_at
+		}
+		FILTER (NOT EXISTS action OR .action = action)
+			AND (NOT EXISTS target_type OR .target_type = target_type)
+			AND (NOT EXISTS target_id OR .target_id = target_id)
+			AND (NOT EXISTS actor_id OR .actor.id = actor_id)
+			AND (NOT EXISTS since OR .created_at >= since)
+			AND (NOT EXISTS until OR .created_at < until)
+		ORDER BY .created_at DESC
+		OFFSET <int64>$offset
+		LIMIT <int64>$limit`,
+		&result,
+		map[string]interface{}{
+			"action":      action,
+			"target_type": targetType,
+			"target_id":   targetID,
+			"actor_id":    actorID,
+			"since":       since,
+			"until":       until,
+			"offset":      offset,
+			"limit":       limit,
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
@@ -2,35 +2,62 @@ package models
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"time"
 
 	"github.com/edgedb/edgedb-go"
 )
 
+var ErrBidNotFound = errors.New("bid does not exist")
+
+// Pinned bids air first, in the order they were pinned
+const bidsQueueOrder = `.pinned_at ASC EMPTY LAST THEN .credits / .audio_duration_seconds DESC THEN .created_at ASC`
+
 type BidCreateResult struct {
 	ID edgedb.UUID `edgedb:"id"`
 }
 
-func BidCreate(ctx context.Context, tx *edgedb.Tx, audioURI string, audioDurationSeconds int64, credits int64) (string, error) {
+// Bids of a user with a stream that has enough pending reports to be escalated start out held, like the bids that were
+// queued when it was escalated
+func BidCreate(ctx context.Context, tx *edgedb.Tx, audioKey string, audioDurationSeconds int64, credits int64, fingerprint []int32, waveformKey string, renditions []AudioRendition, reportThreshold int64) (string, error) {
 	var result BidCreateResult
 
-	err := tx.QuerySingle(
+	renditionsJSON, err := json.Marshal(renditions)
+	if err != nil {
+		return "", err
+	}
+
+	err = tx.QuerySingle(
 		ctx,
-		`INSERT Bid {
-			audio_uri := <str>$audio_uri,
+		`WITH
+			escalated_streams := (
+				SELECT Stream
+				FILTER .user.id = global current_user.id
+					AND count(.<stream[IS Report] FILTER .status = ReportStatus.pending) >= <int64>$report_threshold
+			)
+		INSERT Bid {
+			audio_key := <str>$audio_key,
 			audio_duration_seconds := <int64>$audio_duration_seconds,
 			credits := <int64>$credits,
+			fingerprint := <array<int32>>$fingerprint,
+			waveform_key := <str>$waveform_key,
+			renditions := <json>$renditions,
 			user := (
 				select User
-				filter .identity = (global ext::auth::ClientTokenIdentity)
-			)
+				filter .id = global current_user.id
+			),
+			held_at := datetime_of_statement() IF EXISTS escalated_streams ELSE <datetime>{}
 		}`,
 		&result,
 		map[string]interface{}{
-			"audio_uri":              audioURI,
+			"audio_key":              audioKey,
 			"audio_duration_seconds": audioDurationSeconds,
 			"credits":                credits,
+			"fingerprint":            fingerprint,
+			"waveform_key":           waveformKey,
+			"renditions":             renditionsJSON,
+			"report_threshold":       reportThreshold,
 		},
 	)
 	if err != nil {
@@ -40,11 +67,14 @@ func BidCreate(ctx context.Context, tx *edgedb.Tx, audioURI string, audioDuratio
 }
 
 type BidsFetchResult struct {
-	ID                   edgedb.UUID `json:"id" edgedb:"id"`
-	AudioURI             string      `json:"audio_uri" edgedb:"audio_uri"`
-	AudioDurationSeconds int64       `json:"audio_duration_seconds" edgedb:"audio_duration_seconds"`
-	Credits              int64       `json:"credits" edgedb:"credits"`
-	CreatedAt            time.Time   `json:"created_at" edgedb:"created_at"`
+	ID                   edgedb.UUID             `json:"id" edgedb:"id"`
+	AudioKey             string                  `json:"-" edgedb:"audio_key"`
+	AudioURI             string                  `json:"audio_uri"`
+	AudioDurationSeconds int64                   `json:"audio_duration_seconds" edgedb:"audio_duration_seconds"`
+	Renditions           []AudioRendition        `json:"renditions" edgedb:"renditions"`
+	Credits              int64                   `json:"credits" edgedb:"credits"`
+	HeldAt               edgedb.OptionalDateTime `json:"held_at" edgedb:"held_at"`
+	CreatedAt            time.Time               `json:"created_at" edgedb:"created_at"`
 }
 
 func BidsFetch(ctx context.Context, tx *edgedb.Tx) ([]BidsFetchResult, error) {
@@ -54,12 +84,14 @@ func BidsFetch(ctx context.Context, tx *edgedb.Tx) ([]BidsFetchResult, error) {
 		ctx,
 		`SELECT Bid {
 			id,
-			audio_uri,
+			audio_key,
 			audio_duration_seconds,
+			renditions,
 			credits,
+			held_at,
 			created_at
 		}
-		FILTER .user.identity = (global ext::auth::ClientTokenIdentity)
+		FILTER .user = global current_user
 		ORDER BY .created_at DESC`,
 		&result,
 	)
@@ -70,14 +102,18 @@ func BidsFetch(ctx context.Context, tx *edgedb.Tx) ([]BidsFetchResult, error) {
 }
 
 type BidsTopFetchResult struct {
-	ID                   edgedb.UUID `json:"id" edgedb:"id"`
-	AudioDurationSeconds int64       `json:"audio_duration_seconds" edgedb:"audio_duration_seconds"`
-	Credits              int64       `json:"credits" edgedb:"credits"`
-	CreatedAt            time.Time   `json:"created_at" edgedb:"created_at"`
+	ID                   edgedb.UUID             `json:"id" edgedb:"id"`
+	AudioDurationSeconds int64                   `json:"audio_duration_seconds" edgedb:"audio_duration_seconds"`
+	Credits              int64                   `json:"credits" edgedb:"credits"`
+	WaveformKey          edgedb.OptionalStr      `json:"-" edgedb:"waveform_key"`
+	WaveformURI          *string                 `json:"waveform_uri"`
+	PinnedAt             edgedb.OptionalDateTime `json:"pinned_at" edgedb:"pinned_at"`
+	CreatedAt            time.Time               `json:"created_at" edgedb:"created_at"`
 	User                 struct {
-		ID       edgedb.UUID        `json:"id" edgedb:"id"`
-		Username string             `json:"username" edgedb:"username"`
-		ImageURI edgedb.OptionalStr `json:"image_uri" edgedb:"image_uri"`
+		ID        edgedb.UUID       `json:"id" edgedb:"id"`
+		Username  string            `json:"username" edgedb:"username"`
+		ImageKeys ImageKeys         `json:"-" edgedb:"image_keys"`
+		ImageURI  map[string]string `json:"image_uri"`
 	} `json:"user" edgedb:"user"`
 }
 
@@ -90,14 +126,17 @@ func BidsTopFetch(ctx context.Context, tx *edgedb.Tx) ([]BidsTopFetchResult, err
 			id,
 			audio_duration_seconds,
 			credits,
+			waveform_key,
+			pinned_at,
 			created_at,
 			user: {
 				id,
 				username,
-				image_uri
+				image_keys
 			}
 		}
-		ORDER BY .credits / .audio_duration_seconds DESC THEN .created_at ASC`,
+		FILTER NOT EXISTS .held_at AND .user.is_active
+		ORDER BY `+bidsQueueOrder,
 		&result,
 	)
 	if err != nil {
@@ -108,21 +147,27 @@ func BidsTopFetch(ctx context.Context, tx *edgedb.Tx) ([]BidsTopFetchResult, err
 
 type BidsTopDequeueResult struct {
 	edgedb.Optional
-	ID                   edgedb.UUID `edgedb:"id"`
-	AudioURI             string      `edgedb:"audio_uri"`
-	AudioDurationSeconds int64       `edgedb:"audio_duration_seconds"`
-	Credits              int64       `edgedb:"credits"`
+	ID                   edgedb.UUID        `edgedb:"id"`
+	AudioKey             string             `edgedb:"audio_key"`
+	AudioDurationSeconds int64              `edgedb:"audio_duration_seconds"`
+	Credits              int64              `edgedb:"credits"`
+	Fingerprint          []int32            `edgedb:"fingerprint"`
+	WaveformKey          edgedb.OptionalStr `edgedb:"waveform_key"`
+	Renditions           []AudioRendition   `edgedb:"renditions"`
 	User                 struct {
 		ID edgedb.UUID `edgedb:"id"`
 	} `edgedb:"user"`
 }
 
 type BidsTopDequeueReturn struct {
-	ID                   edgedb.UUID `json:"id"`
-	AudioURI             string      `json:"audio_uri"`
-	AudioDurationSeconds int64       `json:"audio_duration_seconds"`
-	Credits              int64       `json:"credits"`
-	UserID               edgedb.UUID `json:"user_id"`
+	ID                   edgedb.UUID        `json:"id"`
+	AudioKey             string             `json:"audio_key"`
+	AudioDurationSeconds int64              `json:"audio_duration_seconds"`
+	Credits              int64              `json:"credits"`
+	Fingerprint          []int32            `json:"fingerprint"`
+	WaveformKey          edgedb.OptionalStr `json:"waveform_key"`
+	Renditions           []AudioRendition   `json:"renditions"`
+	UserID               edgedb.UUID        `json:"user_id"`
 }
 
 func BidsTopDequeue(ctx context.Context, tx *edgedb.Tx) (*BidsTopDequeueReturn, error) {
@@ -133,14 +178,18 @@ func BidsTopDequeue(ctx context.Context, tx *edgedb.Tx) (*BidsTopDequeueReturn,
 		`WITH
 			bid := (
 				DELETE Bid
-				ORDER BY .credits / .audio_duration_seconds DESC THEN .created_at ASC
+				FILTER NOT EXISTS .held_at AND .user.is_active
+				ORDER BY `+bidsQueueOrder+`
 				LIMIT 1
 			)
 		SELECT bid {
 			id,
-			audio_uri,
+			audio_key,
 			audio_duration_seconds,
 			credits,
+			fingerprint,
+			waveform_key,
+			renditions,
 			user: {
 				id
 			}
@@ -155,35 +204,224 @@ func BidsTopDequeue(ctx context.Context, tx *edgedb.Tx) (*BidsTopDequeueReturn,
 	}
 	return &BidsTopDequeueReturn{
 		ID:                   result.ID,
-		AudioURI:             result.AudioURI,
+		AudioKey:             result.AudioKey,
 		AudioDurationSeconds: result.AudioDurationSeconds,
 		Credits:              result.Credits,
+		Fingerprint:          result.Fingerprint,
+		WaveformKey:          result.WaveformKey,
+		Renditions:           result.Renditions,
 		UserID:               result.User.ID,
 	}, nil
 }
 
-type BidDeleteResult struct {
+type BidFingerprintsFetchResult struct {
+	ID          edgedb.UUID `edgedb:"id"`
+	Fingerprint []int32     `edgedb:"fingerprint"`
+}
+
+// Held bids are included, they air once released
+func BidFingerprintsFetch(ctx context.Context, tx *edgedb.Tx) ([]BidFingerprintsFetchResult, error) {
+	result := []BidFingerprintsFetchResult{}
+
+	err := tx.Query(
+		ctx,
+		`SELECT Bid {
+			id,
+			fingerprint
+		}`,
+		&result,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func BidDelete(ctx context.Context, tx *edgedb.Tx, bidID edgedb.UUID) (*BidRemoveResult, error) {
+	var result BidRemoveResult
+
+	err := tx.QuerySingle(
+		ctx,
+		`SELECT (
+			DELETE Bid
+			FILTER .id = <uuid>$bid_id AND .user = global current_user
+		) {
+			id,
+			audio_duration_seconds,
+			credits,
+			user: {
+				id
+			},
+			created_at
+		}`,
+		&result,
+		map[string]interface{}{
+			"bid_id": bidID,
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+	if result.Missing() {
+		return nil, ErrBidNotFound
+	}
+	return &result, nil
+}
+
+type BidRemoveResult struct {
+	edgedb.Optional
+	ID                   edgedb.UUID `json:"id" edgedb:"id"`
+	AudioDurationSeconds int64       `json:"audio_duration_seconds" edgedb:"audio_duration_seconds"`
+	Credits              int64       `json:"credits" edgedb:"credits"`
+	User                 struct {
+		ID edgedb.UUID `json:"id" edgedb:"id"`
+	} `json:"user" edgedb:"user"`
+	CreatedAt time.Time `json:"created_at" edgedb:"created_at"`
+}
+
+// Deletes the bid of any user, returning what was deleted so it can be refunded and audited
+func BidRemove(ctx context.Context, tx *edgedb.Tx, bidID edgedb.UUID) (*BidRemoveResult, error) {
+	var result BidRemoveResult
+
+	err := tx.QuerySingle(
+		ctx,
+		`SELECT (
+			DELETE Bid
+			FILTER .id = <uuid>$bid_id
+		) {
+			id,
+			audio_duration_seconds,
+			credits,
+			user: {
+				id
+			},
+			created_at
+		}`,
+		&result,
+		map[string]interface{}{
+			"bid_id": bidID,
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+	if result.Missing() {
+		return nil, ErrBidNotFound
+	}
+	return &result, nil
+}
+
+type BidUpdatePinnedResult struct {
 	edgedb.Optional
 	ID edgedb.UUID `edgedb:"id"`
 }
 
-func BidDelete(ctx context.Context, tx *edgedb.Tx, bidID edgedb.UUID) error {
-	var result BidDeleteResult
+func BidUpdatePinned(ctx context.Context, tx *edgedb.Tx, bidID edgedb.UUID, pinned bool) error {
+	var result BidUpdatePinnedResult
 
 	err := tx.QuerySingle(
 		ctx,
-		`DELETE Bid
-		FILTER .id = <uuid>$bid_id AND .user.identity = (global ext::auth::ClientTokenIdentity)`,
+		`UPDATE Bid
+		FILTER .id = <uuid>$bid_id
+		SET {
+			pinned_at := datetime_of_statement() IF <bool>$pinned ELSE <datetime>{}
+		}`,
 		&result,
 		map[string]interface{}{
 			"bid_id": bidID,
+			"pinned": pinned,
 		},
 	)
 	if err != nil {
 		return err
 	}
 	if result.Missing() {
-		return errors.New("bid does not exist")
+		return ErrBidNotFound
 	}
 	return nil
 }
+
+// Takes every queued bid of the user out of the queue, returning how many were held
+func BidsHold(ctx context.Context, tx *edgedb.Tx, userID edgedb.UUID) (int64, error) {
+	var result int64
+
+	err := tx.QuerySingle(
+		ctx,
+		`SELECT count((
+			UPDATE Bid
+			FILTER .user.id = <uuid>$user_id AND NOT EXISTS .held_at
+			SET {
+				held_at := datetime_of_statement()
+			}
+		))`,
+		&result,
+		map[string]interface{}{
+			"user_id": userID,
+		},
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result, nil
+}
+
+// Puts the held bids of the user back into the queue, unless another of their streams still has enough pending
+// reports to be held for. Returns how many were released
+func BidsRelease(ctx context.Context, tx *edgedb.Tx, userID edgedb.UUID, reportThreshold int64) (int64, error) {
+	var result int64
+
+	err := tx.QuerySingle(
+		ctx,
+		`WITH
+			escalated_streams := (
+				SELECT Stream
+				FILTER .user.id = <uuid>$user_id
+					AND count(.<stream[IS Report] FILTER .status = ReportStatus.pending) >= <int64>$report_threshold
+			)
+		SELECT count((
+			UPDATE Bid
+			FILTER .user.id = <uuid>$user_id AND EXISTS .held_at AND NOT EXISTS escalated_streams
+			SET {
+				held_at := {}
+			}
+		))`,
+		&result,
+		map[string]interface{}{
+			"user_id":          userID,
+			"report_threshold": reportThreshold,
+		},
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result, nil
+}
+
+// Deletes every queued bid of the user, held or not, returning what was deleted so it can be refunded and audited
+func BidsRemoveByUser(ctx context.Context, tx *edgedb.Tx, userID edgedb.UUID) ([]BidRemoveResult, error) {
+	result := []BidRemoveResult{}
+
+	err := tx.Query(
+		ctx,
+		`SELECT (
+			DELETE Bid
+			FILTER .user.id = <uuid>$user_id
+		) {
+			id,
+			audio_duration_seconds,
+			credits,
+			user: {
+				id
+			},
+			created_at
+		}`,
+		&result,
+		map[string]interface{}{
+			"user_id": userID,
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
@@ -23,7 +23,7 @@ func DepositsFetch(ctx context.Context, tx *edgedb.Tx) ([]DepositsFetchResult, e
 			credits,
 			created_at
 		}
-		FILTER .user.identity = (global ext::auth::ClientTokenIdentity)
+		FILTER .user = global current_user
 		ORDER BY .created_at DESC`,
 		&result,
 	)
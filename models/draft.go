@@ -0,0 +1,145 @@
+package models
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/edgedb/edgedb-go"
+)
+
+var ErrDraftNotFound = errors.New("draft does not exist")
+
+type DraftCreateResult struct {
+	ID edgedb.UUID `edgedb:"id"`
+}
+
+func DraftCreate(ctx context.Context, tx *edgedb.Tx, audioKey string, audioDurationSeconds int64, fingerprint []int32, waveformKey string, renditions []AudioRendition, expiresAt time.Time) (string, error) {
+	var result DraftCreateResult
+
+	renditionsJSON, err := json.Marshal(renditions)
+	if err != nil {
+		return "", err
+	}
+
+	err = tx.QuerySingle(
+		ctx,
+		`INSERT Draft {
+			audio_key := <str>$audio_key,
+			audio_duration_seconds := <int64>$audio_duration_seconds,
+			fingerprint := <array<int32>>$fingerprint,
+			waveform_key := <str>$waveform_key,
+			renditions := <json>$renditions,
+			expires_at := <datetime>$expires_at,
+			user := (
+				SELECT User
+				FILTER .id = global current_user.id
+			)
+		}`,
+		&result,
+		map[string]interface{}{
+			"audio_key":              audioKey,
+			"audio_duration_seconds": audioDurationSeconds,
+			"fingerprint":            fingerprint,
+			"waveform_key":           waveformKey,
+			"renditions":             renditionsJSON,
+			"expires_at":             expiresAt,
+		},
+	)
+	if err != nil {
+		return "", err
+	}
+	return result.ID.String(), nil
+}
+
+type DraftFetchResult struct {
+	edgedb.Optional
+	ID                   edgedb.UUID      `edgedb:"id"`
+	AudioKey             string           `edgedb:"audio_key"`
+	AudioDurationSeconds int64            `edgedb:"audio_duration_seconds"`
+	Fingerprint          []int32          `edgedb:"fingerprint"`
+	WaveformKey          string           `edgedb:"waveform_key"`
+	Renditions           []AudioRendition `edgedb:"renditions"`
+	ExpiresAt            time.Time        `edgedb:"expires_at"`
+}
+
+func DraftFetch(ctx context.Context, tx *edgedb.Tx, draftID edgedb.UUID) (*DraftFetchResult, error) {
+	var result DraftFetchResult
+
+	err := tx.QuerySingle(
+		ctx,
+		`SELECT Draft {
+			id,
+			audio_key,
+			audio_duration_seconds,
+			fingerprint,
+			waveform_key,
+			renditions,
+			expires_at
+		}
+		FILTER .id = <uuid>$draft_id
+			AND .user = global current_user
+			AND .expires_at > datetime_of_statement()`,
+		&result,
+		map[string]interface{}{
+			"draft_id": draftID,
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+	if result.Missing() {
+		return nil, nil
+	}
+	return &result, nil
+}
+
+type DraftDeleteResult struct {
+	edgedb.Optional
+	ID edgedb.UUID `edgedb:"id"`
+}
+
+func DraftDelete(ctx context.Context, tx *edgedb.Tx, draftID edgedb.UUID) error {
+	var result DraftDeleteResult
+
+	err := tx.QuerySingle(
+		ctx,
+		`DELETE Draft
+		FILTER .id = <uuid>$draft_id AND .user = global current_user`,
+		&result,
+		map[string]interface{}{
+			"draft_id": draftID,
+		},
+	)
+	if err != nil {
+		return err
+	}
+	if result.Missing() {
+		return ErrDraftNotFound
+	}
+	return nil
+}
+
+// Only deletes the rows, the objects are collected once nothing references them. Returns how many were deleted
+func DraftsExpiredDelete(ctx context.Context, tx *edgedb.Tx, limit int64) (int64, error) {
+	var result int64
+
+	err := tx.QuerySingle(
+		ctx,
+		`SELECT count((
+			DELETE Draft
+			FILTER .expires_at <= datetime_of_statement()
+			ORDER BY .expires_at ASC
+			LIMIT <int64>$limit
+		))`,
+		&result,
+		map[string]interface{}{
+			"limit": limit,
+		},
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result, nil
+}
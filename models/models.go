@@ -8,6 +8,18 @@ import (
 	"github.com/edgedb/edgedb-go"
 )
 
+// Renditions are stored with their private object key, URI is only filled with a signed URL in responses
+type AudioRendition struct {
+	Codec       string `json:"codec"`
+	BitrateKbps int    `json:"bitrate_kbps"`
+	ContentType string `json:"content_type"`
+	Key         string `json:"key"`
+	URI         string `json:"uri,omitempty"`
+}
+
+// Avatar object keys by their square size in pixels
+type ImageKeys map[string]string
+
 func NewDBService() (*edgedb.Client, error) {
 	ctx := context.Background()
 	options := edgedb.Options{
@@ -33,11 +45,22 @@ func NewDBService() (*edgedb.Client, error) {
 	return client, nil
 }
 
-func GetTx(client *edgedb.Client, authToken *string) func(ctx context.Context, action edgedb.TxBlock) error {
+// Identifies the caller either by an EdgeDB auth token or, for personal access tokens, by the user the token belongs
+// to. Scopes are nil for auth tokens, which may do anything
+type AuthToken struct {
+	ClientToken string
+	UserID      edgedb.UUID
+	Scopes      []string
+}
+
+func GetTx(client *edgedb.Client, authToken *AuthToken) func(ctx context.Context, action edgedb.TxBlock) error {
 	if authToken == nil {
 		return client.Tx
 	}
-	return client.WithGlobals(map[string]interface{}{"ext::auth::client_token": *authToken}).Tx
+	if authToken.ClientToken == "" {
+		return client.WithGlobals(map[string]interface{}{"default::current_user_id": authToken.UserID}).Tx
+	}
+	return client.WithGlobals(map[string]interface{}{"ext::auth::client_token": authToken.ClientToken}).Tx
 }
 
 func stringPointerToOptionalStr(s *string) edgedb.OptionalStr {
@@ -0,0 +1,53 @@
+package models
+
+import (
+	"context"
+
+	"github.com/edgedb/edgedb-go"
+)
+
+type ObjectsReferencedFetchResult struct {
+	AudioKeys    []string `edgedb:"audio_keys"`
+	WaveformKeys []string `edgedb:"waveform_keys"`
+	ImageKeys    []string `edgedb:"image_keys"`
+}
+
+// Fetches every audio, rendition, waveform and image key still referenced, drafts included. Streams created before
+// streamsSince don't count, so their audio can be collected once the retention period is over
+func ObjectsReferencedFetch(ctx context.Context, tx *edgedb.Tx, streamsSince edgedb.OptionalDateTime) (*ObjectsReferencedFetchResult, error) {
+	var result ObjectsReferencedFetchResult
+
+	err := tx.QuerySingle(
+		ctx,
+		`WITH
+			since := <optional datetime>$streams_since,
+			streams := (
+				SELECT Stream
+				FILTER NOT EXISTS since OR .created_at >= since
+			)
+		SELECT {
+			audio_keys := array_agg(DISTINCT {
+				Bid.audio_key,
+				<str>json_array_unpack(Bid.renditions)['key'],
+				Draft.audio_key,
+				<str>json_array_unpack(Draft.renditions)['key'],
+				streams.audio_key,
+				<str>json_array_unpack(streams.renditions)['key']
+			}),
+			waveform_keys := array_agg(DISTINCT {
+				Bid.waveform_key,
+				Draft.waveform_key,
+				streams.waveform_key
+			}),
+			image_keys := array_agg(DISTINCT <str>json_object_unpack(User.image_keys).1)
+		}`,
+		&result,
+		map[string]interface{}{
+			"streams_since": streamsSince,
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
@@ -0,0 +1,186 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/edgedb/edgedb-go"
+)
+
+var ErrReportNotFound = errors.New("report does not exist")
+
+type ReportCreateResult struct {
+	ID      edgedb.UUID `json:"id" edgedb:"id"`
+	Created bool        `json:"-" edgedb:"created"`
+}
+
+// Reporting the same stream again returns the existing report instead, Created tells the two apart
+func ReportCreate(ctx context.Context, tx *edgedb.Tx, streamID edgedb.UUID, category string, details edgedb.OptionalStr) (*ReportCreateResult, error) {
+	var result ReportCreateResult
+
+	err := tx.QuerySingle(
+		ctx,
+		`SELECT (
+			INSERT Report {
+				category := <ReportCategory><str>$category,
+				details := <optional str>$details,
+				stream := (SELECT Stream FILTER .id = <uuid>$stream_id),
+				reporter := global current_user
+			}
+			UNLESS CONFLICT ON (.stream, .reporter) ELSE (SELECT Report)
+		) {
+			id,
+			created := .created_at = datetime_of_statement()
+		}`,
+		&result,
+		map[string]interface{}{
+			"stream_id": streamID,
+			"category":  category,
+			"details":   details,
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+func StreamPendingReportsCount(ctx context.Context, tx *edgedb.Tx, streamID edgedb.UUID) (int64, error) {
+	var result int64
+
+	err := tx.QuerySingle(
+		ctx,
+		`SELECT count(
+			Report
+			FILTER .stream.id = <uuid>$stream_id AND .status = ReportStatus.pending
+		)`,
+		&result,
+		map[string]interface{}{
+			"stream_id": streamID,
+		},
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result, nil
+}
+
+type ReportsFetchResult struct {
+	ID           edgedb.UUID             `json:"id" edgedb:"id"`
+	Category     string                  `json:"category" edgedb:"category"`
+	Details      edgedb.OptionalStr      `json:"details" edgedb:"details"`
+	Status       string                  `json:"status" edgedb:"status"`
+	ReviewedAt   edgedb.OptionalDateTime `json:"reviewed_at" edgedb:"reviewed_at"`
+	ReviewReason edgedb.OptionalStr      `json:"review_reason" edgedb:"review_reason"`
+	CreatedAt    time.Time               `json:"created_at" edgedb:"created_at"`
+	Reporter     struct {
+		ID       edgedb.UUID `json:"id" edgedb:"id"`
+		Username string      `json:"username" edgedb:"username"`
+	} `json:"reporter" edgedb:"reporter"`
+	Stream struct {
+		ID                   edgedb.UUID             `json:"id" edgedb:"id"`
+		AudioDurationSeconds int64                   `json:"audio_duration_seconds" edgedb:"audio_duration_seconds"`
+		PendingReports       int64                   `json:"pending_reports" edgedb:"pending_reports"`
+		CreatedAt            time.Time               `json:"created_at" edgedb:"created_at"`
+		SkippedAt            edgedb.OptionalDateTime `json:"skipped_at" edgedb:"skipped_at"`
+		User                 struct {
+			ID       edgedb.UUID `json:"id" edgedb:"id"`
+			Username string      `json:"username" edgedb:"username"`
+		} `json:"user" edgedb:"user"`
+	} `json:"stream" edgedb:"stream"`
+}
+
+// Lists reports with the given status, the streams with the most pending reports first
+func ReportsFetch(ctx context.Context, tx *edgedb.Tx, status string, offset int64, limit int64) ([]ReportsFetchResult, error) {
+	result := []ReportsFetchResult{}
+
+	err := tx.Query(
+		ctx,
+		`SELECT Report {
+			id,
+			category,
+			details,
+			status,
+			reviewed_at,
+			review_reason,
+			created_at,
+			reporter: {
+				id,
+				username
+			},
+			stream: {
+				id,
+				audio_duration_seconds,
+				pending_reports := count(.<stream[IS Report] FILTER .status = ReportStatus.pending),
+				created_at,
+				skipped_at,
+				user: {
+					id,
+					username
+				}
+			}
+		}
+		FILTER .status = <ReportStatus><str>$status
+		ORDER BY count(.stream.<stream[IS Report] FILTER .status = ReportStatus.pending) DESC
+			THEN .created_at ASC
+		OFFSET <int64>$offset
+		LIMIT <int64>$limit`,
+		&result,
+		map[string]interface{}{
+			"status": status,
+			"offset": offset,
+			"limit":  limit,
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+type ReportsResolveResult struct {
+	edgedb.Optional
+	StreamID     edgedb.UUID `edgedb:"stream_id"`
+	StreamUserID edgedb.UUID `edgedb:"stream_user_id"`
+	Resolved     int64       `edgedb:"resolved"`
+}
+
+// Resolves the report together with every other pending report of its stream, as the decision is about the stream
+func ReportsResolve(ctx context.Context, tx *edgedb.Tx, reportID edgedb.UUID, status string, reason string) (*ReportsResolveResult, error) {
+	var result ReportsResolveResult
+
+	err := tx.QuerySingle(
+		ctx,
+		`WITH
+			report := (SELECT Report FILTER .id = <uuid>$report_id),
+			resolved := (
+				UPDATE Report
+				FILTER .stream = report.stream AND (.status = ReportStatus.pending OR Report = report)
+				SET {
+					status := <ReportStatus><str>$status,
+					reviewed_by := global current_user,
+					reviewed_at := datetime_of_statement(),
+					review_reason := <str>$reason
+				}
+			)
+		SELECT report {
+			stream_id := .stream.id,
+			stream_user_id := .stream.user.id,
+			resolved := count(resolved)
+		}`,
+		&result,
+		map[string]interface{}{
+			"report_id": reportID,
+			"status":    status,
+			"reason":    reason,
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+	if result.Missing() {
+		return nil, ErrReportNotFound
+	}
+	return &result, nil
+}
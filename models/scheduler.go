@@ -0,0 +1,50 @@
+package models
+
+import (
+	"context"
+
+	"github.com/edgedb/edgedb-go"
+)
+
+type SchedulerStateFetchResult struct {
+	Paused    bool                    `json:"paused" edgedb:"paused"`
+	UpdatedAt edgedb.OptionalDateTime `json:"updated_at" edgedb:"updated_at"`
+}
+
+// The state only exists once the scheduler was paused for the first time, until then it isn't paused
+func SchedulerStateFetch(ctx context.Context, tx *edgedb.Tx) (*SchedulerStateFetchResult, error) {
+	var result SchedulerStateFetchResult
+
+	err := tx.QuerySingle(
+		ctx,
+		`SELECT {
+			paused := (SELECT SchedulerState.paused LIMIT 1) ?? false,
+			updated_at := (SELECT SchedulerState.updated_at LIMIT 1)
+		}`,
+		&result,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+func SchedulerStateUpdate(ctx context.Context, tx *edgedb.Tx, paused bool) error {
+	return tx.Execute(
+		ctx,
+		`INSERT SchedulerState {
+			paused := <bool>$paused,
+			updated_at := datetime_of_statement()
+		}
+		UNLESS CONFLICT ON .singleton ELSE (
+			UPDATE SchedulerState
+			SET {
+				paused := <bool>$paused,
+				updated_at := datetime_of_statement()
+			}
+		)`,
+		map[string]interface{}{
+			"paused": paused,
+		},
+	)
+}
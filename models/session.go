@@ -0,0 +1,170 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/edgedb/edgedb-go"
+)
+
+var ErrSessionNotFound = errors.New("session does not exist")
+
+type SessionCreateResult struct {
+	ID edgedb.UUID `edgedb:"id"`
+}
+
+func SessionCreate(ctx context.Context, tx *edgedb.Tx, tokenHash string, userAgent string, ip string, expiresAt time.Time) (string, error) {
+	var result SessionCreateResult
+
+	err := tx.QuerySingle(
+		ctx,
+		`INSERT Session {
+			token_hash := <str>$token_hash,
+			user_agent := <str>$user_agent,
+			ip := <str>$ip,
+			expires_at := <datetime>$expires_at,
+			user := (
+				SELECT User
+				FILTER .id = global current_user.id
+			)
+		}
+		UNLESS CONFLICT ON .token_hash ELSE (SELECT Session)`,
+		&result,
+		map[string]interface{}{
+			"token_hash": tokenHash,
+			"user_agent": userAgent,
+			"ip":         ip,
+			"expires_at": expiresAt,
+		},
+	)
+	if err != nil {
+		return "", err
+	}
+	return result.ID.String(), nil
+}
+
+type SessionTouchResult struct {
+	edgedb.Optional
+	Revoked bool `edgedb:"revoked"`
+}
+
+// Updates when and from where the session was last seen, at most once a minute, and reports whether it was revoked or
+// expired. Returns nil for tokens without a session, like those issued before sessions were tracked
+func SessionTouch(ctx context.Context, tx *edgedb.Tx, tokenHash string, ip string) (*SessionTouchResult, error) {
+	var result SessionTouchResult
+
+	err := tx.QuerySingle(
+		ctx,
+		`WITH
+			session := (SELECT Session FILTER .token_hash = <str>$token_hash),
+			touched := (
+				UPDATE session
+				FILTER NOT EXISTS .revoked_at
+					AND .expires_at > datetime_of_statement()
+					AND .last_seen_at < datetime_of_statement() - <duration>'1 minute'
+				SET {
+					last_seen_at := datetime_of_statement(),
+					ip := <str>$ip
+				}
+			)
+		SELECT session {
+			revoked := EXISTS .revoked_at OR .expires_at <= datetime_of_statement()
+		}`,
+		&result,
+		map[string]interface{}{
+			"token_hash": tokenHash,
+			"ip":         ip,
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+	if result.Missing() {
+		return nil, nil
+	}
+	return &result, nil
+}
+
+type SessionsFetchResult struct {
+	ID         edgedb.UUID `json:"id" edgedb:"id"`
+	TokenHash  string      `json:"-" edgedb:"token_hash"`
+	UserAgent  string      `json:"user_agent" edgedb:"user_agent"`
+	IP         string      `json:"ip" edgedb:"ip"`
+	CreatedAt  time.Time   `json:"created_at" edgedb:"created_at"`
+	LastSeenAt time.Time   `json:"last_seen_at" edgedb:"last_seen_at"`
+	ExpiresAt  time.Time   `json:"expires_at" edgedb:"expires_at"`
+	Current    bool        `json:"current"`
+}
+
+func SessionsFetch(ctx context.Context, tx *edgedb.Tx) ([]SessionsFetchResult, error) {
+	result := []SessionsFetchResult{}
+
+	err := tx.Query(
+		ctx,
+		`SELECT Session {
+			id,
+			token_hash,
+			user_agent,
+			ip,
+			created_at,
+			last_seen_at,
+			expires_at
+		}
+		FILTER .user = global current_user
+			AND NOT EXISTS .revoked_at
+			AND .expires_at > datetime_of_statement()
+		ORDER BY .last_seen_at DESC`,
+		&result,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+type SessionRevokeResult struct {
+	edgedb.Optional
+	ID edgedb.UUID `edgedb:"id"`
+}
+
+func SessionRevoke(ctx context.Context, tx *edgedb.Tx, sessionID edgedb.UUID) error {
+	var result SessionRevokeResult
+
+	err := tx.QuerySingle(
+		ctx,
+		`UPDATE Session
+		FILTER .id = <uuid>$session_id
+			AND .user = global current_user
+			AND NOT EXISTS .revoked_at
+		SET {
+			revoked_at := datetime_of_statement()
+		}`,
+		&result,
+		map[string]interface{}{
+			"session_id": sessionID,
+		},
+	)
+	if err != nil {
+		return err
+	}
+	if result.Missing() {
+		return ErrSessionNotFound
+	}
+	return nil
+}
+
+// Revokes the session of the given token, doing nothing for tokens without a session
+func SessionRevokeByToken(ctx context.Context, tx *edgedb.Tx, tokenHash string) error {
+	return tx.Execute(
+		ctx,
+		`UPDATE Session
+		FILTER .token_hash = <str>$token_hash AND NOT EXISTS .revoked_at
+		SET {
+			revoked_at := datetime_of_statement()
+		}`,
+		map[string]interface{}{
+			"token_hash": tokenHash,
+		},
+	)
+}
@@ -2,17 +2,23 @@ package models
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"time"
 
 	"github.com/edgedb/edgedb-go"
 )
 
+var ErrStreamNotFound = errors.New("stream does not exist")
+
 type StreamFetchResult struct {
-	ID                   edgedb.UUID `json:"id" edgedb:"id"`
-	AudioUri             string      `json:"audio_uri" edgedb:"audio_uri"`
-	AudioDurationSeconds int64       `json:"audio_duration_seconds" edgedb:"audio_duration_seconds"`
-	Credits              int64       `json:"credits" edgedb:"credits"`
-	CreatedAt            time.Time   `json:"created_at" edgedb:"created_at"`
+	ID                   edgedb.UUID      `json:"id" edgedb:"id"`
+	AudioKey             string           `json:"-" edgedb:"audio_key"`
+	AudioURI             string           `json:"audio_uri"`
+	AudioDurationSeconds int64            `json:"audio_duration_seconds" edgedb:"audio_duration_seconds"`
+	Renditions           []AudioRendition `json:"renditions" edgedb:"renditions"`
+	Credits              int64            `json:"credits" edgedb:"credits"`
+	CreatedAt            time.Time        `json:"created_at" edgedb:"created_at"`
 }
 
 func StreamFetch(ctx context.Context, tx *edgedb.Tx) ([]StreamFetchResult, error) {
@@ -22,12 +28,13 @@ func StreamFetch(ctx context.Context, tx *edgedb.Tx) ([]StreamFetchResult, error
 		ctx,
 		`SELECT Stream {
 			id,
-			audio_uri,
+			audio_key,
 			audio_duration_seconds,
+			renditions,
 			credits,
 			created_at
 		}
-		FILTER .user.identity = (global ext::auth::ClientTokenIdentity)
+		FILTER .user = global current_user
 		ORDER BY .created_at DESC`,
 		&result,
 	)
@@ -38,16 +45,30 @@ func StreamFetch(ctx context.Context, tx *edgedb.Tx) ([]StreamFetchResult, error
 }
 
 type StreamLatestFetchResult struct {
-	ID                   edgedb.UUID `json:"id" edgedb:"id"`
-	AudioURI             string      `json:"audio_uri" edgedb:"audio_uri"`
-	AudioDurationSeconds int64       `json:"audio_duration_seconds" edgedb:"audio_duration_seconds"`
-	Credits              int64       `json:"credits" edgedb:"credits"`
+	ID                   edgedb.UUID        `json:"id" edgedb:"id"`
+	AudioKey             string             `json:"-" edgedb:"audio_key"`
+	AudioURI             *string            `json:"audio_uri"`
+	AudioDurationSeconds int64              `json:"audio_duration_seconds" edgedb:"audio_duration_seconds"`
+	Credits              int64              `json:"credits" edgedb:"credits"`
+	WaveformKey          edgedb.OptionalStr `json:"-" edgedb:"waveform_key"`
+	WaveformURI          *string            `json:"waveform_uri"`
+	Renditions           []AudioRendition   `json:"renditions" edgedb:"renditions"`
 	User                 struct {
-		ID       edgedb.UUID        `json:"id" edgedb:"id"`
-		Username string             `json:"username" edgedb:"username"`
-		ImageURI edgedb.OptionalStr `json:"image_uri" edgedb:"image_uri"`
+		ID        edgedb.UUID       `json:"id" edgedb:"id"`
+		Username  string            `json:"username" edgedb:"username"`
+		ImageKeys ImageKeys         `json:"-" edgedb:"image_keys"`
+		ImageURI  map[string]string `json:"image_uri"`
 	} `json:"user" edgedb:"user"`
-	CreatedAt time.Time `json:"created_at" edgedb:"created_at"`
+	CreatedAt time.Time               `json:"created_at" edgedb:"created_at"`
+	SkippedAt edgedb.OptionalDateTime `json:"skipped_at" edgedb:"skipped_at"`
+}
+
+// When the stream stops airing, skipped streams stop before their audio ends
+func (stream *StreamLatestFetchResult) AirEnd() time.Time {
+	if skippedAt, ok := stream.SkippedAt.Get(); ok {
+		return skippedAt
+	}
+	return stream.CreatedAt.Add(time.Duration(stream.AudioDurationSeconds) * time.Second)
 }
 
 func StreamLastestFetch(ctx context.Context, tx *edgedb.Tx, offset int64, limit int64) ([]StreamLatestFetchResult, error) {
@@ -57,16 +78,20 @@ func StreamLastestFetch(ctx context.Context, tx *edgedb.Tx, offset int64, limit
 		ctx,
 		`SELECT Stream {
 			id,
-			audio_uri,
+			audio_key,
 			audio_duration_seconds,
 			credits,
+			waveform_key,
+			renditions,
 			user: {
 				id,
 				username,
-				image_uri
+				image_keys
 			},
-			created_at
+			created_at,
+			skipped_at
 		}
+		FILTER .user.is_active
 		ORDER BY .created_at DESC
 		OFFSET <int64>$offset
 		LIMIT <int64>$limit`,
@@ -86,15 +111,23 @@ type StreamCreateResult struct {
 	ID edgedb.UUID `edgedb:"id"`
 }
 
-func StreamCreate(ctx context.Context, tx *edgedb.Tx, audioURI string, audioDurationSeconds int64, credits int64, userID edgedb.UUID) (string, error) {
+func StreamCreate(ctx context.Context, tx *edgedb.Tx, audioKey string, audioDurationSeconds int64, credits int64, fingerprint []int32, waveformKey edgedb.OptionalStr, renditions []AudioRendition, userID edgedb.UUID) (string, error) {
 	var result StreamCreateResult
 
-	err := tx.QuerySingle(
+	renditionsJSON, err := json.Marshal(renditions)
+	if err != nil {
+		return "", err
+	}
+
+	err = tx.QuerySingle(
 		ctx,
 		`INSERT Stream {
-			audio_uri := <str>$audio_uri,
+			audio_key := <str>$audio_key,
 			audio_duration_seconds := <int64>$audio_duration_seconds,
 			credits := <int64>$credits,
+			fingerprint := <array<int32>>$fingerprint,
+			waveform_key := <optional str>$waveform_key,
+			renditions := <json>$renditions,
 			user := (
 				select User
 				filter .id = <uuid>$user_id
@@ -102,9 +135,12 @@ func StreamCreate(ctx context.Context, tx *edgedb.Tx, audioURI string, audioDura
 		}`,
 		&result,
 		map[string]interface{}{
-			"audio_uri":              audioURI,
+			"audio_key":              audioKey,
 			"audio_duration_seconds": audioDurationSeconds,
 			"credits":                credits,
+			"fingerprint":            fingerprint,
+			"waveform_key":           waveformKey,
+			"renditions":             renditionsJSON,
 			"user_id":                userID,
 		},
 	)
@@ -113,3 +149,124 @@ func StreamCreate(ctx context.Context, tx *edgedb.Tx, audioURI string, audioDura
 	}
 	return result.ID.String(), nil
 }
+
+type StreamFingerprintsFetchResult struct {
+	ID          edgedb.UUID `edgedb:"id"`
+	Fingerprint []int32     `edgedb:"fingerprint"`
+	CreatedAt   time.Time   `edgedb:"created_at"`
+}
+
+func StreamFingerprintsFetch(ctx context.Context, tx *edgedb.Tx, since time.Time) ([]StreamFingerprintsFetchResult, error) {
+	result := []StreamFingerprintsFetchResult{}
+
+	err := tx.Query(
+		ctx,
+		`SELECT Stream {
+			id,
+			fingerprint,
+			created_at
+		}
+		FILTER .created_at >= <datetime>$since
+		ORDER BY .created_at ASC`,
+		&result,
+		map[string]any{
+			"since": since,
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+type StreamSkipResult struct {
+	edgedb.Optional
+	ID                   edgedb.UUID `json:"id" edgedb:"id"`
+	AudioDurationSeconds int64       `json:"audio_duration_seconds" edgedb:"audio_duration_seconds"`
+	Credits              int64       `json:"credits" edgedb:"credits"`
+	User                 struct {
+		ID edgedb.UUID `json:"id" edgedb:"id"`
+	} `json:"user" edgedb:"user"`
+	CreatedAt time.Time `json:"created_at" edgedb:"created_at"`
+}
+
+// Ends the latest stream now if it's still airing, and if a stream ID is given only if it's that stream. Returns nil
+// when nothing was skipped
+func StreamSkip(ctx context.Context, tx *edgedb.Tx, streamID edgedb.OptionalUUID) (*StreamSkipResult, error) {
+	var result StreamSkipResult
+
+	err := tx.QuerySingle(
+		ctx,
+		`WITH
+			stream_id := <optional uuid>$stream_id,
+			latest := (
+				SELECT Stream
+				ORDER BY .created_at DESC
+				LIMIT 1
+			)
+		SELECT (
+			UPDATE latest
+			FILTER (NOT EXISTS stream_id OR .id = stream_id)
+				AND NOT EXISTS .skipped_at
+				AND .created_at + to_duration(seconds := <float64>.audio_duration_seconds) > datetime_of_statement()
+			SET {
+				skipped_at := datetime_of_statement()
+			}
+		) {
+			id,
+			audio_duration_seconds,
+			credits,
+			user: {
+				id
+			},
+			created_at
+		}`,
+		&result,
+		map[string]interface{}{
+			"stream_id": streamID,
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+	if result.Missing() {
+		return nil, nil
+	}
+	return &result, nil
+}
+
+type StreamOwnerFetchResult struct {
+	edgedb.Optional
+	ID   edgedb.UUID `edgedb:"id"`
+	User struct {
+		ID edgedb.UUID `edgedb:"id"`
+	} `edgedb:"user"`
+	Own bool `edgedb:"own"`
+}
+
+func StreamOwnerFetch(ctx context.Context, tx *edgedb.Tx, streamID edgedb.UUID) (*StreamOwnerFetchResult, error) {
+	var result StreamOwnerFetchResult
+
+	err := tx.QuerySingle(
+		ctx,
+		`SELECT Stream {
+			id,
+			user: {
+				id
+			},
+			own := .user ?= global current_user
+		}
+		FILTER .id = <uuid>$stream_id`,
+		&result,
+		map[string]interface{}{
+			"stream_id": streamID,
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+	if result.Missing() {
+		return nil, ErrStreamNotFound
+	}
+	return &result, nil
+}
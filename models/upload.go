@@ -0,0 +1,300 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/edgedb/edgedb-go"
+)
+
+type UploadCreateResult struct {
+	ID edgedb.UUID `edgedb:"id"`
+}
+
+func UploadCreate(ctx context.Context, tx *edgedb.Tx, remoteUploadID string, objectName string, size int64, expiresAt time.Time) (string, error) {
+	var result UploadCreateResult
+
+	err := tx.QuerySingle(
+		ctx,
+		`INSERT Upload {
+			remote_upload_id := <str>$remote_upload_id,
+			object_name := <str>$object_name,
+			size := <int64>$size,
+			expires_at := <datetime>$expires_at,
+			user := (
+				SELECT User
+				FILTER .id = global current_user.id
+			)
+		}`,
+		&result,
+		map[string]interface{}{
+			"remote_upload_id": remoteUploadID,
+			"object_name":      objectName,
+			"size":             size,
+			"expires_at":       expiresAt,
+		},
+	)
+	if err != nil {
+		return "", err
+	}
+	return result.ID.String(), nil
+}
+
+type UploadFetchResult struct {
+	edgedb.Optional
+	ID             edgedb.UUID `json:"id" edgedb:"id"`
+	RemoteUploadID string      `json:"-" edgedb:"remote_upload_id"`
+	ObjectName     string      `json:"-" edgedb:"object_name"`
+	Size           int64       `json:"size" edgedb:"size"`
+	Offset         int64       `json:"offset" edgedb:"offset"`
+	PartCount      int64       `json:"-" edgedb:"part_count"`
+	Completed      bool        `json:"completed" edgedb:"completed"`
+	CreatedAt      time.Time   `json:"created_at" edgedb:"created_at"`
+}
+
+func UploadFetch(ctx context.Context, tx *edgedb.Tx, uploadID edgedb.UUID) (*UploadFetchResult, error) {
+	var result UploadFetchResult
+
+	err := tx.QuerySingle(
+		ctx,
+		`SELECT Upload {
+			id,
+			remote_upload_id,
+			object_name,
+			size,
+			offset,
+			part_count,
+			completed,
+			created_at
+		}
+		FILTER .id = <uuid>$upload_id
+			AND .user = global current_user
+			AND .expires_at > datetime_of_statement()`,
+		&result,
+		map[string]interface{}{
+			"upload_id": uploadID,
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+	if result.Missing() {
+		return nil, nil
+	}
+	return &result, nil
+}
+
+type UploadClaimResult struct {
+	edgedb.Optional
+	Offset    int64 `edgedb:"offset"`
+	PartCount int64 `edgedb:"part_count"`
+	// Missing when the offset changed or another append holds the claim
+	AppendToken edgedb.OptionalUUID `edgedb:"append_token"`
+}
+
+// Claims the next part of the upload at the expected offset, unless another append holds an unexpired claim. Returns
+// nil when the upload doesn't exist
+func UploadClaim(ctx context.Context, tx *edgedb.Tx, uploadID edgedb.UUID, expectedOffset int64, claimExpiresAt time.Time) (*UploadClaimResult, error) {
+	var result UploadClaimResult
+
+	err := tx.QuerySingle(
+		ctx,
+		`WITH
+			upload := (
+				SELECT Upload
+				FILTER .id = <uuid>$upload_id
+					AND .user = global current_user
+					AND .expires_at > datetime_of_statement()
+			),
+			claimed := (
+				UPDATE upload
+				FILTER .offset = <int64>$expected_offset
+					AND (.append_expires_at ?? datetime_of_statement()) <= datetime_of_statement()
+				SET {
+					append_token := uuid_generate_v4(),
+					append_expires_at := <datetime>$claim_expires_at
+				}
+			)
+		SELECT upload {
+			offset,
+			part_count,
+			append_token := claimed.append_token
+		}`,
+		&result,
+		map[string]interface{}{
+			"upload_id":        uploadID,
+			"expected_offset":  expectedOffset,
+			"claim_expires_at": claimExpiresAt,
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+	if result.Missing() {
+		return nil, nil
+	}
+	return &result, nil
+}
+
+type UploadAdvanceResult struct {
+	edgedb.Optional
+	ID edgedb.UUID `edgedb:"id"`
+}
+
+// Records the claimed part and releases the claim. Appending a chunk keeps the upload alive for another expiry period
+func UploadAdvance(ctx context.Context, tx *edgedb.Tx, uploadID edgedb.UUID, appendToken edgedb.UUID, length int64, expiresAt time.Time) error {
+	var result UploadAdvanceResult
+
+	err := tx.QuerySingle(
+		ctx,
+		`UPDATE Upload
+		FILTER .id = <uuid>$upload_id
+			AND .user = global current_user
+			AND .append_token = <uuid>$append_token
+		SET {
+			offset := .offset + <int64>$length,
+			part_count := .part_count + 1,
+			expires_at := <datetime>$expires_at,
+			append_token := {},
+			append_expires_at := {}
+		}`,
+		&result,
+		map[string]interface{}{
+			"upload_id":    uploadID,
+			"append_token": appendToken,
+			"length":       length,
+			"expires_at":   expiresAt,
+		},
+	)
+	if err != nil {
+		return err
+	}
+	if result.Missing() {
+		return errors.New("upload does not exist or its claim was lost")
+	}
+	return nil
+}
+
+// Releases the claim of an append that failed, so the part can be appended again right away
+func UploadRelease(ctx context.Context, tx *edgedb.Tx, uploadID edgedb.UUID, appendToken edgedb.UUID) error {
+	var result UploadAdvanceResult
+
+	return tx.QuerySingle(
+		ctx,
+		`UPDATE Upload
+		FILTER .id = <uuid>$upload_id
+			AND .user = global current_user
+			AND .append_token = <uuid>$append_token
+		SET {
+			append_token := {},
+			append_expires_at := {}
+		}`,
+		&result,
+		map[string]interface{}{
+			"upload_id":    uploadID,
+			"append_token": appendToken,
+		},
+	)
+}
+
+// Marks the upload as assembled into its object, giving the user another expiry period to place a bid from it
+func UploadComplete(ctx context.Context, tx *edgedb.Tx, uploadID edgedb.UUID, expiresAt time.Time) error {
+	var result UploadAdvanceResult
+
+	err := tx.QuerySingle(
+		ctx,
+		`UPDATE Upload
+		FILTER .id = <uuid>$upload_id
+			AND .user = global current_user
+			AND .offset = .size
+		SET {
+			completed := true,
+			expires_at := <datetime>$expires_at
+		}`,
+		&result,
+		map[string]interface{}{
+			"upload_id":  uploadID,
+			"expires_at": expiresAt,
+		},
+	)
+	if err != nil {
+		return err
+	}
+	if result.Missing() {
+		return errors.New("upload does not exist or is incomplete")
+	}
+	return nil
+}
+
+type UploadDeleteResult struct {
+	edgedb.Optional
+	ID edgedb.UUID `edgedb:"id"`
+}
+
+func UploadDelete(ctx context.Context, tx *edgedb.Tx, uploadID edgedb.UUID) error {
+	var result UploadDeleteResult
+
+	err := tx.QuerySingle(
+		ctx,
+		`DELETE Upload
+		FILTER .id = <uuid>$upload_id AND .user = global current_user`,
+		&result,
+		map[string]interface{}{
+			"upload_id": uploadID,
+		},
+	)
+	if err != nil {
+		return err
+	}
+	if result.Missing() {
+		return errors.New("upload does not exist")
+	}
+	return nil
+}
+
+type UploadsExpiredFetchResult struct {
+	ID             edgedb.UUID `edgedb:"id"`
+	RemoteUploadID string      `edgedb:"remote_upload_id"`
+	ObjectName     string      `edgedb:"object_name"`
+	Completed      bool        `edgedb:"completed"`
+}
+
+func UploadsExpiredFetch(ctx context.Context, tx *edgedb.Tx, limit int64) ([]UploadsExpiredFetchResult, error) {
+	result := []UploadsExpiredFetchResult{}
+
+	err := tx.Query(
+		ctx,
+		`SELECT Upload {
+			id,
+			remote_upload_id,
+			object_name,
+			completed
+		}
+		FILTER .expires_at <= datetime_of_statement()
+		ORDER BY .expires_at ASC
+		LIMIT <int64>$limit`,
+		&result,
+		map[string]interface{}{
+			"limit": limit,
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func UploadsDeleteByIDs(ctx context.Context, tx *edgedb.Tx, uploadIDs []edgedb.UUID) error {
+	var result []UploadDeleteResult
+
+	return tx.Query(
+		ctx,
+		`DELETE Upload
+		FILTER .id IN array_unpack(<array<uuid>>$upload_ids)`,
+		&result,
+		map[string]interface{}{
+			"upload_ids": uploadIDs,
+		},
+	)
+}
@@ -2,20 +2,28 @@ package models
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
+	"slices"
 	"time"
 
 	"github.com/anandvarma/namegen"
 	"github.com/edgedb/edgedb-go"
 )
 
+var ErrUserNotFound = errors.New("user does not exist")
+
 type UserCreateResult struct {
 	ID edgedb.UUID `edgedb:"id"`
 }
 
-func UserCreate(ctx context.Context, tx *edgedb.Tx) error {
+func generateUsername() string {
 	generator := namegen.NewWithPostfixId([]namegen.DictType{namegen.Adjectives, namegen.Colors, namegen.Animals}, namegen.Numeric, 4)
-	username := generator.Get()
+	return generator.Get()
+}
+
+func UserCreate(ctx context.Context, tx *edgedb.Tx) (string, error) {
+	username := generateUsername()
 
 	var result UserCreateResult
 
@@ -31,18 +39,25 @@ func UserCreate(ctx context.Context, tx *edgedb.Tx) error {
 			"username": username,
 		})
 	if err != nil {
-		return err
+		return "", err
 	}
-	return nil
+	return result.ID.String(), nil
 }
 
 type UserFetchResult struct {
 	edgedb.Optional
-	ID        edgedb.UUID        `json:"id" edgedb:"id"`
-	Username  string             `json:"username" edgedb:"username"`
-	Credits   int64              `json:"credits" edgedb:"credits"`
-	ImageURI  edgedb.OptionalStr `json:"image_uri" edgedb:"image_uri"`
-	CreatedAt time.Time          `json:"created_at" edgedb:"created_at"`
+	ID        edgedb.UUID       `json:"id" edgedb:"id"`
+	Username  string            `json:"username" edgedb:"username"`
+	Credits   int64             `json:"credits" edgedb:"credits"`
+	ImageKeys ImageKeys         `json:"-" edgedb:"image_keys"`
+	ImageURI  map[string]string `json:"image_uri"`
+	CreatedAt time.Time         `json:"created_at" edgedb:"created_at"`
+	// Missing until the generated username is changed for the first time
+	UsernameChangedAt edgedb.OptionalDateTime `json:"username_changed_at" edgedb:"username_changed_at"`
+	// The status only differs from active while a suspension or ban is in effect, the reason is shown to the user
+	AccountStatus  string                  `json:"account_status" edgedb:"account_status"`
+	SuspendedUntil edgedb.OptionalDateTime `json:"suspended_until" edgedb:"suspended_until"`
+	StatusReason   edgedb.OptionalStr      `json:"status_reason" edgedb:"status_reason"`
 }
 
 func UserFetch(ctx context.Context, tx *edgedb.Tx) (*UserFetchResult, error) {
@@ -54,10 +69,14 @@ func UserFetch(ctx context.Context, tx *edgedb.Tx) (*UserFetchResult, error) {
 			id,
 			username,
 			credits,
-			image_uri,
-			created_at
+			image_keys,
+			created_at,
+			username_changed_at,
+			account_status := 'active' IF .is_active ELSE <str>.account_status,
+			suspended_until := <datetime>{} IF .is_active ELSE .suspended_until,
+			status_reason := <str>{} IF .is_active ELSE .status_reason
 		}
-		FILTER .identity = (global ext::auth::ClientTokenIdentity)`,
+		FILTER .id = global current_user.id`,
 		&result,
 	)
 	if err != nil {
@@ -80,7 +99,7 @@ func UserDecrementCredits(ctx context.Context, tx *edgedb.Tx, amount int64) erro
 	err := tx.QuerySingle(
 		ctx,
 		`UPDATE User
-		FILTER .identity = (global ext::auth::ClientTokenIdentity)
+		FILTER .id = global current_user.id
 		SET {
 			credits := .credits - <int64>$amount
 		}`,
@@ -93,25 +112,32 @@ func UserDecrementCredits(ctx context.Context, tx *edgedb.Tx, amount int64) erro
 		return err
 	}
 	if result.Missing() {
-		return errors.New("user does not exist")
+		return ErrUserNotFound
 	}
 	return nil
 }
 
 type UserIncrementCreditsResult struct {
 	edgedb.Optional
-	ID edgedb.UUID `edgedb:"id"`
+	ID      edgedb.UUID `edgedb:"id"`
+	Credits int64       `edgedb:"credits"`
 }
 
-func UserIncrementCredits(ctx context.Context, tx *edgedb.Tx, userID edgedb.UUID, amount int64) error {
+// Returns the credits of the user after the increment
+func UserIncrementCredits(ctx context.Context, tx *edgedb.Tx, userID edgedb.UUID, amount int64) (int64, error) {
 	var result UserIncrementCreditsResult
 
 	err := tx.QuerySingle(
 		ctx,
-		`UPDATE User
-		FILTER .id = <uuid>$user_id
-		SET {
-			credits := .credits + <int64>$amount
+		`SELECT (
+			UPDATE User
+			FILTER .id = <uuid>$user_id
+			SET {
+				credits := .credits + <int64>$amount
+			}
+		) {
+			id,
+			credits
 		}`,
 		&result,
 		map[string]interface{}{
@@ -120,12 +146,12 @@ func UserIncrementCredits(ctx context.Context, tx *edgedb.Tx, userID edgedb.UUID
 		},
 	)
 	if err != nil {
-		return err
+		return 0, err
 	}
 	if result.Missing() {
-		return errors.New("user does not exist")
+		return 0, ErrUserNotFound
 	}
-	return nil
+	return result.Credits, nil
 }
 
 type UserUpdateResult struct {
@@ -138,10 +164,15 @@ func UserUpdate(ctx context.Context, tx *edgedb.Tx, username *string) error {
 
 	err := tx.QuerySingle(
 		ctx,
-		`UPDATE User
-		FILTER .identity = (global ext::auth::ClientTokenIdentity)
-		SET {
+		`WITH
 			username := <optional str>$username
+		UPDATE User
+		FILTER .id = global current_user.id
+		SET {
+			username := username ?? .username,
+			username_changed_at := (
+				datetime_of_statement() IF (username ?? .username) != .username ELSE .username_changed_at
+			)
 		}`,
 		&result,
 		map[string]interface{}{
@@ -152,36 +183,240 @@ func UserUpdate(ctx context.Context, tx *edgedb.Tx, username *string) error {
 		return err
 	}
 	if result.Missing() {
-		return errors.New("user does not exist")
+		return ErrUserNotFound
 	}
 	return nil
 }
 
+// Picks the first of a few generated usernames that is still free. Returns an empty string in the unlikely case that
+// every one of them is taken
+func UsernameSuggest(ctx context.Context, tx *edgedb.Tx) (string, error) {
+	candidates := make([]string, 5)
+	for i := range candidates {
+		candidates[i] = generateUsername()
+	}
+
+	var taken []string
+	err := tx.Query(
+		ctx,
+		`SELECT User.username
+		FILTER User.username IN array_unpack(<array<str>>$candidates)`,
+		&taken,
+		map[string]interface{}{
+			"candidates": candidates,
+		},
+	)
+	if err != nil {
+		return "", err
+	}
+
+	for _, candidate := range candidates {
+		if !slices.Contains(taken, candidate) {
+			return candidate, nil
+		}
+	}
+	return "", nil
+}
+
 type UserUpdateImageResult struct {
 	edgedb.Optional
 	ID edgedb.UUID `edgedb:"id"`
 }
 
-func UserUpdateImage(ctx context.Context, tx *edgedb.Tx, imageURI string) error {
-	var result UserIncrementCreditsResult
+func UserUpdateImage(ctx context.Context, tx *edgedb.Tx, imageKeys ImageKeys) error {
+	var result UserUpdateImageResult
+
+	imageKeysJSON, err := json.Marshal(imageKeys)
+	if err != nil {
+		return err
+	}
+
+	err = tx.QuerySingle(
+		ctx,
+		`UPDATE User
+		FILTER .id = global current_user.id
+		SET {
+			image_keys := <json>$image_keys
+		}`,
+		&result,
+		map[string]interface{}{
+			"image_keys": imageKeysJSON,
+		},
+	)
+	if err != nil {
+		return err
+	}
+	if result.Missing() {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+func UserRolesFetch(ctx context.Context, tx *edgedb.Tx) ([]string, error) {
+	result := []string{}
+
+	err := tx.Query(
+		ctx,
+		`SELECT <str>(global current_user).roles`,
+		&result,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+type UsersFetchResult struct {
+	ID             edgedb.UUID             `json:"id" edgedb:"id"`
+	Username       string                  `json:"username" edgedb:"username"`
+	Credits        int64                   `json:"credits" edgedb:"credits"`
+	Roles          []string                `json:"roles" edgedb:"roles"`
+	CreatedAt      time.Time               `json:"created_at" edgedb:"created_at"`
+	AccountStatus  string                  `json:"account_status" edgedb:"account_status"`
+	SuspendedUntil edgedb.OptionalDateTime `json:"suspended_until" edgedb:"suspended_until"`
+	StatusReason   edgedb.OptionalStr      `json:"status_reason" edgedb:"status_reason"`
+}
+
+// Lists users whose username contains the search term, or every user without one
+func UsersFetch(ctx context.Context, tx *edgedb.Tx, search edgedb.OptionalStr, offset int64, limit int64) ([]UsersFetchResult, error) {
+	result := []UsersFetchResult{}
+
+	err := tx.Query(
+		ctx,
+		`WITH
+			search := <optional str>$search
+		SELECT User {
+			id,
+			username,
+			credits,
+			roles := array_agg(<str>.roles),
+			created_at,
+			account_status := <str>.account_status,
+			suspended_until,
+			status_reason
+		}
+		FILTER NOT EXISTS search OR .username ILIKE '%' ++ search ++ '%'
+		ORDER BY .created_at DESC
+		OFFSET <int64>$offset
+		LIMIT <int64>$limit`,
+		&result,
+		map[string]interface{}{
+			"search": search,
+			"offset": offset,
+			"limit":  limit,
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+type UserUpdateRolesResult struct {
+	edgedb.Optional
+	ID            edgedb.UUID `edgedb:"id"`
+	PreviousRoles []string    `edgedb:"previous_roles"`
+}
+
+// Returns the roles the user had before the update
+func UserUpdateRoles(ctx context.Context, tx *edgedb.Tx, userID edgedb.UUID, roles []string) ([]string, error) {
+	var result UserUpdateRolesResult
+
+	err := tx.QuerySingle(
+		ctx,
+		`WITH
+			user := (SELECT User FILTER .id = <uuid>$user_id),
+			previous_roles := array_agg(<str>user.roles)
+		SELECT (
+			UPDATE user
+			SET {
+				roles := <Role>array_unpack(<array<str>>$roles)
+			}
+		) {
+			id,
+			previous_roles := previous_roles
+		}`,
+		&result,
+		map[string]interface{}{
+			"user_id": userID,
+			"roles":   roles,
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+	if result.Missing() {
+		return nil, ErrUserNotFound
+	}
+	return result.PreviousRoles, nil
+}
+
+type UserStatusFetchResult struct {
+	edgedb.Optional
+	AccountStatus  string                  `json:"account_status" edgedb:"account_status"`
+	SuspendedUntil edgedb.OptionalDateTime `json:"suspended_until" edgedb:"suspended_until"`
+	StatusReason   edgedb.OptionalStr      `json:"status_reason" edgedb:"status_reason"`
+	Roles          []string                `json:"-" edgedb:"roles"`
+	IsCurrentUser  bool                    `json:"-" edgedb:"is_current_user"`
+}
+
+func UserStatusFetch(ctx context.Context, tx *edgedb.Tx, userID edgedb.UUID) (*UserStatusFetchResult, error) {
+	var result UserStatusFetchResult
+
+	err := tx.QuerySingle(
+		ctx,
+		`SELECT User {
+			account_status := <str>.account_status,
+			suspended_until,
+			status_reason,
+			roles := <str>.roles,
+			is_current_user := (.id = global current_user.id) ?? false
+		}
+		FILTER .id = <uuid>$user_id`,
+		&result,
+		map[string]interface{}{
+			"user_id": userID,
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+	if result.Missing() {
+		return nil, ErrUserNotFound
+	}
+	return &result, nil
+}
+
+type UserUpdateStatusResult struct {
+	edgedb.Optional
+	ID edgedb.UUID `edgedb:"id"`
+}
+
+func UserUpdateStatus(ctx context.Context, tx *edgedb.Tx, userID edgedb.UUID, accountStatus string, suspendedUntil edgedb.OptionalDateTime, reason edgedb.OptionalStr) error {
+	var result UserUpdateStatusResult
 
 	err := tx.QuerySingle(
 		ctx,
 		`UPDATE User
-		FILTER .identity = (global ext::auth::ClientTokenIdentity)
+		FILTER .id = <uuid>$user_id
 		SET {
-			image_uri := <str>$image_uri
+			account_status := <AccountStatus><str>$account_status,
+			suspended_until := <optional datetime>$suspended_until,
+			status_reason := <optional str>$reason
 		}`,
 		&result,
 		map[string]interface{}{
-			"image_uri": imageURI,
+			"user_id":         userID,
+			"account_status":  accountStatus,
+			"suspended_until": suspendedUntil,
+			"reason":          reason,
 		},
 	)
 	if err != nil {
 		return err
 	}
 	if result.Missing() {
-		return errors.New("user does not exist")
+		return ErrUserNotFound
 	}
 	return nil
 }
@@ -0,0 +1,81 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+var ErrBlobNotFound = errors.New("blob not found")
+
+// Bucket is a logical bucket, each store maps it to its own bucket or directory
+type Bucket string
+
+const (
+	BucketAudio        Bucket = "audio"
+	BucketPrivateAudio Bucket = "private-audio"
+	BucketImage        Bucket = "images"
+	BucketUpload       Bucket = "uploads"
+)
+
+var buckets = []Bucket{BucketAudio, BucketPrivateAudio, BucketImage, BucketUpload}
+
+type BlobInfo struct {
+	Size         int64
+	ContentType  string
+	LastModified time.Time
+}
+
+// BlobStore stores objects by bucket and key. Audio and image objects are public, private audio and upload objects are
+// only reachable through signed URLs. Resumable uploads are built on the multipart methods, which only apply to the upload bucket
+type BlobStore interface {
+	Put(ctx context.Context, bucket Bucket, key string, reader io.Reader, size int64, contentType string) error
+	Get(ctx context.Context, bucket Bucket, key string) (io.ReadCloser, error)
+	Delete(ctx context.Context, bucket Bucket, key string) error
+	Stat(ctx context.Context, bucket Bucket, key string) (*BlobInfo, error)
+	List(ctx context.Context, bucket Bucket, fn func(key string, info *BlobInfo) error) error
+	PublicURL(bucket Bucket, key string) string
+	SignedURL(ctx context.Context, bucket Bucket, key string, expiry time.Duration) (string, error)
+
+	CreateMultipart(ctx context.Context, key string) (string, error)
+	PutPart(ctx context.Context, key string, uploadID string, partNumber int, reader io.Reader, size int64) error
+	CompleteMultipart(ctx context.Context, key string, uploadID string) error
+	AbortMultipart(ctx context.Context, key string, uploadID string) error
+}
+
+func NewBlobStore() (BlobStore, error) {
+	switch blobStore := os.Getenv("BLOB_STORE"); blobStore {
+	case "", "s3":
+		s3Service, err := NewS3Service()
+		if err != nil {
+			return nil, fmt.Errorf("failed to create S3 service: %w", err)
+		}
+		return s3Service, nil
+	case "local":
+		localBlobStore, err := NewLocalBlobStore()
+		if err != nil {
+			return nil, fmt.Errorf("failed to create local blob store: %w", err)
+		}
+		return localBlobStore, nil
+	default:
+		return nil, fmt.Errorf("BLOB_STORE environment variable must be s3 or local: %s", blobStore)
+	}
+}
+
+func PutFile(ctx context.Context, store BlobStore, bucket Bucket, key string, filePath string, contentType string) error {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return fmt.Errorf("failed to open file: %w", err)
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to stat file: %w", err)
+	}
+
+	return store.Put(ctx, bucket, key, f, info.Size(), contentType)
+}
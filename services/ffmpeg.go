@@ -1,92 +1,190 @@
 package services
 
 import (
+	"bytes"
 	"context"
-	"crypto/rand"
+	"encoding/binary"
 	"fmt"
 	"io"
-	"math"
-	"math/big"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"regexp"
+	"slices"
 	"strconv"
 )
 
 var DurationRegex = regexp.MustCompile(`size=.*time=(.*):(.*):(.*)\..*bitrate=.*speed=.*`)
 
-func ProcessAudio(ctx context.Context, reader io.Reader) (string, int64, error) {
-	number, err := rand.Int(rand.Reader, big.NewInt(math.MaxInt64))
+// Mono PCM sample rate the transcoded audio is decoded at for fingerprinting and waveform generation
+const AnalysisSampleRate = 11025
+
+type ProcessedAudio struct {
+	FilePath        string
+	Extension       string
+	ContentType     string
+	DurationSeconds int64
+	Fingerprint     []int32
+	Waveform        *Waveform
+	Renditions      []ProcessedRendition
+	dir             string
+}
+
+func (audio *ProcessedAudio) Remove() {
+	os.RemoveAll(audio.dir)
+}
+
+func (sandbox *FFmpegSandbox) ProcessAudio(ctx context.Context, reader io.Reader, renditionProfiles []RenditionProfile, tags map[string]string) (*ProcessedAudio, error) {
+	dir, err := newJobDir()
 	if err != nil {
-		return "", 0, fmt.Errorf("failed to generate random number: %w", err)
+		return nil, err
+	}
+
+	audio := &ProcessedAudio{
+		FilePath:    filepath.Join(dir, "audio.mp3"),
+		Extension:   "mp3",
+		ContentType: "audio/mpeg",
+		dir:         dir,
+	}
+
+	outputArgs := metadataArgs(tags)
+
+	args := []string{"-hide_banner", "-nostats", "-vn", "-i", "-"}
+	args = append(args, outputArgs...)
+	args = append(args, audio.FilePath)
+	for _, profile := range renditionProfiles {
+		renditionFileName := filepath.Join(dir, fmt.Sprintf("audio-%s.%s", profile.Name(), profile.Extension))
+		audio.Renditions = append(audio.Renditions, ProcessedRendition{Profile: profile, FilePath: renditionFileName})
+		args = append(args, outputArgs...)
+		args = append(args, profile.ffmpegArgs(renditionFileName)...)
 	}
 
-	targetFileName := filepath.Join(os.TempDir(), fmt.Sprintf("audio-%d.mp3", number.Uint64()))
+	var stderr bytes.Buffer
+	if err := sandbox.run(ctx, dir, reader, nil, &stderr, args...); err != nil {
+		audio.Remove()
+		return nil, fmt.Errorf("failed to run ffmpeg: %w", err)
+	}
+	stderrBytes := stderr.Bytes()
 
-	cmd := exec.CommandContext(ctx, "./ffmpeg", "-hide_banner", "-nostats", "-vn", "-i", "-", targetFileName)
-	cmd.Stdin = reader
-	stderr, err := cmd.StderrPipe()
+	durationSeconds, err := parseDuration(stderrBytes)
 	if err != nil {
-		return "", 0, fmt.Errorf("failed to create stderr pipe: %w", err)
+		audio.Remove()
+		return nil, err
 	}
 
-	if err := cmd.Start(); err != nil {
-		return "", 0, fmt.Errorf("failed to start processing audio: %w", err)
+	if durationSeconds <= 0 {
+		audio.Remove()
+		return nil, fmt.Errorf("failed to find positive duration: `%v`", string(stderrBytes))
 	}
 
-	stderrBytes, err := io.ReadAll(stderr)
+	samples, err := sandbox.decodeAudio(ctx, dir, audio.FilePath, AnalysisSampleRate)
 	if err != nil {
-		return "", 0, fmt.Errorf("failed to read stderr: %w", err)
+		audio.Remove()
+		return nil, err
+	}
+
+	audio.DurationSeconds = durationSeconds
+	audio.Fingerprint = Fingerprint(samples)
+	audio.Waveform = NewWaveform(samples, AnalysisSampleRate)
+
+	return audio, nil
+}
+
+// Only the first audio stream is kept, and every tag, chapter, cover art and encoder signature from the input is
+// dropped before writing the given tags
+func metadataArgs(tags map[string]string) []string {
+	args := []string{"-map", "0:a:0", "-map_metadata", "-1", "-map_chapters", "-1", "-fflags", "+bitexact", "-flags:a", "+bitexact"}
+
+	keys := make([]string, 0, len(tags))
+	for key := range tags {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+
+	for _, key := range keys {
+		args = append(args, "-metadata", fmt.Sprintf("%s=%s", key, tags[key]))
 	}
 
+	return args
+}
+
+func parseDuration(stderrBytes []byte) (int64, error) {
 	durationMatch := DurationRegex.FindSubmatch(stderrBytes)
 	if len(durationMatch) != 4 {
-		return "", 0, fmt.Errorf("failed to find duration: `%v`", string(stderrBytes))
+		return 0, fmt.Errorf("failed to find duration: `%v`", string(stderrBytes))
 	}
 
 	hours, err := strconv.ParseInt(string(durationMatch[1]), 10, 64)
 	if err != nil {
-		return "", 0, fmt.Errorf("failed to parse hours: %w", err)
+		return 0, fmt.Errorf("failed to parse hours: %w", err)
 	}
 
 	minutes, err := strconv.ParseInt(string(durationMatch[2]), 10, 64)
 	if err != nil {
-		return "", 0, fmt.Errorf("failed to parse minutes: %w", err)
+		return 0, fmt.Errorf("failed to parse minutes: %w", err)
 	}
 
 	seconds, err := strconv.ParseInt(string(durationMatch[3]), 10, 64)
 	if err != nil {
-		return "", 0, fmt.Errorf("failed to parse seconds: %w", err)
+		return 0, fmt.Errorf("failed to parse seconds: %w", err)
 	}
 
-	durationSeconds := hours*3600 + minutes*60 + seconds
+	return hours*3600 + minutes*60 + seconds, nil
+}
 
-	if err := cmd.Wait(); err != nil {
-		return "", 0, fmt.Errorf("failed to run ffmpeg: %w", err)
+func (sandbox *FFmpegSandbox) decodeAudio(ctx context.Context, dir string, filePath string, sampleRate int) ([]int16, error) {
+	var stdout bytes.Buffer
+	err := sandbox.run(ctx, dir, nil, &stdout, nil, "-hide_banner", "-nostats", "-i", filePath, "-vn", "-ac", "1", "-ar", strconv.Itoa(sampleRate), "-f", "s16le", "-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode audio: %w", err)
 	}
 
-	if durationSeconds <= 0 {
-		return "", 0, fmt.Errorf("failed to find positive duration: `%v`", string(stderrBytes))
+	stdoutBytes := stdout.Bytes()
+	samples := make([]int16, len(stdoutBytes)/2)
+	for i := range samples {
+		samples[i] = int16(binary.LittleEndian.Uint16(stdoutBytes[i*2:]))
 	}
 
-	return targetFileName, durationSeconds, nil
+	return samples, nil
 }
 
-func ProcessImage(ctx context.Context, reader io.Reader) (string, error) {
-	number, err := rand.Int(rand.Reader, big.NewInt(math.MaxInt64))
+func (sandbox *FFmpegSandbox) ProcessImage(ctx context.Context, reader io.Reader, sizes []int) (*ProcessedImage, error) {
+	data, _, err := readImage(reader)
 	if err != nil {
-		return "", fmt.Errorf("failed to generate random number: %w", err)
+		return nil, err
 	}
 
-	targetFileName := filepath.Join(os.TempDir(), fmt.Sprintf("audio-%d.webp", number.Uint64()))
+	dir, err := newJobDir()
+	if err != nil {
+		return nil, err
+	}
+
+	image := &ProcessedImage{
+		Extension:   "webp",
+		ContentType: "image/webp",
+		dir:         dir,
+	}
 
-	cmd := exec.CommandContext(ctx, "./ffmpeg", "-hide_banner", "-nostats", "-i", "-", targetFileName)
-	cmd.Stdin = reader
+	// Center crop to a square, then split the result into one scaled output per size
+	filter := fmt.Sprintf("[0:v:0]crop='min(iw,ih)':'min(iw,ih)',split=%d", len(sizes))
+	for i := range sizes {
+		filter += fmt.Sprintf("[c%d]", i)
+	}
+	for i, size := range sizes {
+		filter += fmt.Sprintf(";[c%d]scale=%d:%d:flags=lanczos[s%d]", i, size, size, i)
+	}
+
+	args := []string{"-hide_banner", "-nostats", "-i", "-", "-filter_complex", filter}
+	for i, size := range sizes {
+		filePath := filepath.Join(dir, imageSizeFileName(size, image.Extension))
+		image.Sizes = append(image.Sizes, ProcessedImageSize{Size: size, FilePath: filePath})
+		// Only the first frame is kept and EXIF and every other metadata is dropped
+		args = append(args, "-map", fmt.Sprintf("[s%d]", i), "-frames:v", "1", "-map_metadata", "-1", "-fflags", "+bitexact", filePath)
+	}
 
-	if err := cmd.Run(); err != nil {
-		return "", fmt.Errorf("failed to run ffmpeg: %w", err)
+	if err := sandbox.run(ctx, dir, bytes.NewReader(data), nil, nil, args...); err != nil {
+		image.Remove()
+		return nil, fmt.Errorf("failed to run ffmpeg: %w", err)
 	}
 
-	return targetFileName, nil
+	return image, nil
 }
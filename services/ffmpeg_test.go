@@ -0,0 +1,153 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Values written into the input that must not show up in any output
+var inputTagValues = []string{"Secret Title", "Secret Artist", "Secret Comment", "+48.8584+002.2945", "Secret Encoder", "Secret Cover"}
+
+func TestMetadataArgs(t *testing.T) {
+	args := metadataArgs(map[string]string{"publisher": "World Sounds", "album": "Air"})
+
+	for _, pair := range [][]string{{"-map", "0:a:0"}, {"-map_metadata", "-1"}, {"-map_chapters", "-1"}} {
+		index := slices.Index(args, pair[0])
+		if index == -1 || index+1 >= len(args) || args[index+1] != pair[1] {
+			t.Errorf("args %v lack %s %s", args, pair[0], pair[1])
+		}
+	}
+
+	tags := []string{}
+	for i, arg := range args {
+		if arg == "-metadata" {
+			tags = append(tags, args[i+1])
+		}
+	}
+	if !slices.Equal(tags, []string{"album=Air", "publisher=World Sounds"}) {
+		t.Errorf("tags are %v, want only the given tags in key order", tags)
+	}
+}
+
+// Needs an ffmpeg binary, either from FFMPEG_PATH or the PATH
+func testFFmpegSandbox(t *testing.T) *FFmpegSandbox {
+	t.Helper()
+
+	binaryPath := os.Getenv("FFMPEG_PATH")
+	if binaryPath == "" {
+		var err error
+		binaryPath, err = exec.LookPath("ffmpeg")
+		if err != nil {
+			t.Skip("ffmpeg is not available, set FFMPEG_PATH to run this test")
+		}
+	}
+
+	return &FFmpegSandbox{
+		binaryPath: binaryPath,
+		semaphore:  make(chan struct{}, 1),
+		timeout:    time.Minute,
+	}
+}
+
+func TestFFmpegProcessAudioStripsInputTags(t *testing.T) {
+	sandbox := testFFmpegSandbox(t)
+	dir := t.TempDir()
+
+	// An MP3 with ID3 tags, a GPS location, an encoder comment and embedded cover art
+	inputPath := filepath.Join(dir, "input.mp3")
+	err := exec.Command(sandbox.binaryPath, "-hide_banner", "-loglevel", "error",
+		"-f", "lavfi", "-i", "sine=frequency=440:duration=3",
+		"-f", "lavfi", "-i", "color=c=red:s=64x64:d=1",
+		"-map", "0:a", "-map", "1:v", "-c:v", "mjpeg", "-frames:v", "1", "-disposition:v", "attached_pic",
+		"-id3v2_version", "3",
+		"-metadata", "title=Secret Title",
+		"-metadata", "artist=Secret Artist",
+		"-metadata", "comment=Secret Comment",
+		"-metadata", "location=+48.8584+002.2945",
+		"-metadata", "encoded_by=Secret Encoder",
+		"-metadata:s:v", "title=Secret Cover",
+		inputPath,
+	).Run()
+	if err != nil {
+		t.Fatalf("failed to create input: %v", err)
+	}
+	if metadata := dumpMetadata(t, sandbox, inputPath); !strings.Contains(metadata, "Secret Title") {
+		t.Fatalf("input lacks its tags, the test would prove nothing: %s", metadata)
+	}
+
+	input, err := os.Open(inputPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer input.Close()
+
+	profiles, err := ParseRenditionProfiles("opus:48,aac:96,mp3:128")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	audio, err := sandbox.ProcessAudio(context.Background(), input, profiles, map[string]string{"publisher": "World Sounds"})
+	if err != nil {
+		t.Fatalf("failed to process audio: %v", err)
+	}
+	defer audio.Remove()
+
+	outputPaths := []string{audio.FilePath}
+	for _, rendition := range audio.Renditions {
+		outputPaths = append(outputPaths, rendition.FilePath)
+	}
+
+	for _, outputPath := range outputPaths {
+		metadata := dumpMetadata(t, sandbox, outputPath)
+		for _, value := range inputTagValues {
+			if strings.Contains(metadata, value) {
+				t.Errorf("%s kept the input tag %q: %s", filepath.Base(outputPath), value, metadata)
+			}
+		}
+		if !strings.Contains(metadata, "World Sounds") {
+			t.Errorf("%s lacks the publisher tag: %s", filepath.Base(outputPath), metadata)
+		}
+
+		data, err := os.ReadFile(outputPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, value := range inputTagValues {
+			if bytes.Contains(data, []byte(value)) {
+				t.Errorf("%s contains the input tag %q", filepath.Base(outputPath), value)
+			}
+		}
+		if probe := probeStreams(t, sandbox, outputPath); strings.Contains(probe, "Video:") {
+			t.Errorf("%s kept the cover art: %s", filepath.Base(outputPath), probe)
+		}
+	}
+}
+
+// Returns the global and per stream tags of the file in the ffmetadata format
+func dumpMetadata(t *testing.T, sandbox *FFmpegSandbox, filePath string) string {
+	t.Helper()
+
+	output, err := exec.Command(sandbox.binaryPath, "-hide_banner", "-loglevel", "error", "-i", filePath, "-map", "0", "-c", "copy", "-f", "ffmetadata", "-").Output()
+	if err != nil {
+		t.Fatalf("failed to dump metadata of %s: %v", filePath, err)
+	}
+	return string(output)
+}
+
+func probeStreams(t *testing.T, sandbox *FFmpegSandbox, filePath string) string {
+	t.Helper()
+
+	// Without an output file ffmpeg prints the streams and exits with an error, which is expected
+	var stderr bytes.Buffer
+	cmd := exec.Command(sandbox.binaryPath, "-hide_banner", "-i", filePath)
+	cmd.Stderr = &stderr
+	cmd.Run()
+	return stderr.String()
+}
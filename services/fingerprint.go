@@ -0,0 +1,130 @@
+package services
+
+import (
+	"math"
+	"math/bits"
+	"math/cmplx"
+)
+
+const (
+	fingerprintFrameSize = 4096
+	fingerprintHopSize   = 1024
+	fingerprintBands     = 33
+	fingerprintMinFreq   = 300
+	fingerprintMaxFreq   = 2000
+
+	// Maximum number of frames two fingerprints are shifted against each other when comparing, about 5 seconds
+	fingerprintMaxShift = 5 * AnalysisSampleRate / fingerprintHopSize
+	// Bit error rate below which two fingerprints are considered the same audio
+	fingerprintMatchThreshold = 0.35
+)
+
+// Each frame is encoded as 32 bits, one per sign of the energy difference between adjacent bands compared to the
+// previous frame, which survives transcoding and volume changes
+func Fingerprint(samples []int16) []int32 {
+	if len(samples) < fingerprintFrameSize {
+		return []int32{}
+	}
+
+	window := make([]float64, fingerprintFrameSize)
+	for i := range window {
+		window[i] = 0.5 - 0.5*math.Cos(2*math.Pi*float64(i)/float64(fingerprintFrameSize-1))
+	}
+
+	bandEdges := make([]int, fingerprintBands+1)
+	for i := range bandEdges {
+		freq := fingerprintMinFreq * math.Pow(fingerprintMaxFreq/fingerprintMinFreq, float64(i)/fingerprintBands)
+		bandEdges[i] = int(freq * fingerprintFrameSize / AnalysisSampleRate)
+	}
+
+	result := []int32{}
+	frame := make([]complex128, fingerprintFrameSize)
+	var previousEnergies []float64
+	for start := 0; start+fingerprintFrameSize <= len(samples); start += fingerprintHopSize {
+		for i := range frame {
+			frame[i] = complex(float64(samples[start+i])*window[i], 0)
+		}
+		fft(frame)
+
+		energies := make([]float64, fingerprintBands)
+		for band := range energies {
+			for bin := bandEdges[band]; bin < bandEdges[band+1]; bin++ {
+				magnitude := cmplx.Abs(frame[bin])
+				energies[band] += magnitude * magnitude
+			}
+		}
+
+		if previousEnergies != nil {
+			var subFingerprint uint32
+			for band := 0; band < fingerprintBands-1; band++ {
+				difference := (energies[band] - energies[band+1]) - (previousEnergies[band] - previousEnergies[band+1])
+				if difference > 0 {
+					subFingerprint |= 1 << band
+				}
+			}
+			result = append(result, int32(subFingerprint))
+		}
+		previousEnergies = energies
+	}
+
+	return result
+}
+
+func FingerprintsMatch(a []int32, b []int32) bool {
+	if len(a) == 0 || len(b) == 0 {
+		return false
+	}
+
+	minOverlap := min(len(a), len(b)) / 2
+	if minOverlap == 0 {
+		minOverlap = 1
+	}
+
+	for shift := -fingerprintMaxShift; shift <= fingerprintMaxShift; shift++ {
+		errorBits, comparedBits := 0, 0
+		for i := max(0, -shift); i < len(a) && i+shift < len(b); i++ {
+			errorBits += bits.OnesCount32(uint32(a[i] ^ b[i+shift]))
+			comparedBits += 32
+		}
+
+		if comparedBits/32 < minOverlap {
+			continue
+		}
+
+		if float64(errorBits)/float64(comparedBits) < fingerprintMatchThreshold {
+			return true
+		}
+	}
+
+	return false
+}
+
+func fft(values []complex128) {
+	n := len(values)
+
+	for i, j := 1, 0; i < n; i++ {
+		bit := n >> 1
+		for ; j&bit != 0; bit >>= 1 {
+			j ^= bit
+		}
+		j ^= bit
+		if i < j {
+			values[i], values[j] = values[j], values[i]
+		}
+	}
+
+	for length := 2; length <= n; length <<= 1 {
+		angle := -2 * math.Pi / float64(length)
+		step := cmplx.Rect(1, angle)
+		for start := 0; start < n; start += length {
+			twiddle := complex(1, 0)
+			for k := 0; k < length/2; k++ {
+				even := values[start+k]
+				odd := values[start+k+length/2] * twiddle
+				values[start+k] = even + odd
+				values[start+k+length/2] = even - odd
+				twiddle *= step
+			}
+		}
+	}
+}
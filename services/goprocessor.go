@@ -0,0 +1,344 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/png"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+
+	"github.com/hajimehoshi/go-mp3"
+	"golang.org/x/image/draw"
+)
+
+// GoProcessor decodes WAV and MP3 audio and PNG, JPEG, GIF and WebP images without the ffmpeg binary. It can't encode
+// compressed formats, so audio is written as 16-bit mono WAV, images as PNG and rendition profiles are ignored. Only the sample
+// data is copied, so no input metadata survives and tags aren't written
+type GoProcessor struct{}
+
+func NewGoProcessor() *GoProcessor {
+	return &GoProcessor{}
+}
+
+type decodedAudio struct {
+	container  string
+	codec      string
+	sampleRate int
+	channels   int
+	samples    []int16
+}
+
+func (processor *GoProcessor) ProbeAudio(ctx context.Context, filePath string) (*AudioProbe, error) {
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read audio: %w", err)
+	}
+
+	audio, err := decodeAudioBytes(data)
+	if err != nil {
+		return nil, err
+	}
+
+	return &AudioProbe{
+		Containers:      []string{audio.container},
+		Codec:           audio.codec,
+		DurationSeconds: float64(len(audio.samples)) / float64(audio.sampleRate),
+		SampleRate:      audio.sampleRate,
+		Channels:        audio.channels,
+	}, nil
+}
+
+func (processor *GoProcessor) ProcessAudio(ctx context.Context, reader io.Reader, renditionProfiles []RenditionProfile, tags map[string]string) (*ProcessedAudio, error) {
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read audio: %w", err)
+	}
+
+	decoded, err := decodeAudioBytes(data)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	dir, err := newJobDir()
+	if err != nil {
+		return nil, err
+	}
+
+	audio := &ProcessedAudio{
+		FilePath:        filepath.Join(dir, "audio.wav"),
+		Extension:       "wav",
+		ContentType:     "audio/wav",
+		DurationSeconds: int64(len(decoded.samples) / decoded.sampleRate),
+		dir:             dir,
+	}
+
+	if err := os.WriteFile(audio.FilePath, encodeWAV(decoded.samples, decoded.sampleRate), 0o600); err != nil {
+		audio.Remove()
+		return nil, fmt.Errorf("failed to write audio: %w", err)
+	}
+
+	if audio.DurationSeconds <= 0 {
+		audio.Remove()
+		return nil, errors.New("failed to find positive duration")
+	}
+
+	samples := resample(decoded.samples, decoded.sampleRate, AnalysisSampleRate)
+	audio.Fingerprint = Fingerprint(samples)
+	audio.Waveform = NewWaveform(samples, AnalysisSampleRate)
+
+	return audio, nil
+}
+
+func (processor *GoProcessor) ProcessImage(ctx context.Context, reader io.Reader, sizes []int) (*ProcessedImage, error) {
+	data, config, err := readImage(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	decoded, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode image: %w", err)
+	}
+
+	dir, err := newJobDir()
+	if err != nil {
+		return nil, err
+	}
+
+	processedImage := &ProcessedImage{
+		Extension:   "png",
+		ContentType: "image/png",
+		dir:         dir,
+	}
+
+	side := min(config.Width, config.Height)
+	bounds := decoded.Bounds()
+	crop := image.Rect(0, 0, side, side).Add(bounds.Min).Add(image.Pt((bounds.Dx()-side)/2, (bounds.Dy()-side)/2))
+
+	for _, size := range sizes {
+		if err := ctx.Err(); err != nil {
+			processedImage.Remove()
+			return nil, err
+		}
+
+		resized := image.NewRGBA(image.Rect(0, 0, size, size))
+		draw.CatmullRom.Scale(resized, resized.Bounds(), decoded, crop, draw.Src, nil)
+
+		filePath := filepath.Join(dir, imageSizeFileName(size, processedImage.Extension))
+		if err := writePNG(filePath, resized); err != nil {
+			processedImage.Remove()
+			return nil, err
+		}
+		processedImage.Sizes = append(processedImage.Sizes, ProcessedImageSize{Size: size, FilePath: filePath})
+	}
+
+	return processedImage, nil
+}
+
+func writePNG(filePath string, img image.Image) error {
+	f, err := os.Create(filePath)
+	if err != nil {
+		return fmt.Errorf("failed to create image: %w", err)
+	}
+	defer f.Close()
+
+	if err := png.Encode(f, img); err != nil {
+		return fmt.Errorf("failed to encode image: %w", err)
+	}
+
+	return nil
+}
+
+func decodeAudioBytes(data []byte) (*decodedAudio, error) {
+	switch {
+	case len(data) >= 12 && string(data[0:4]) == "RIFF" && string(data[8:12]) == "WAVE":
+		return decodeWAV(data)
+	case len(data) >= 3 && string(data[0:3]) == "ID3", len(data) >= 2 && data[0] == 0xFF && data[1]&0xE0 == 0xE0:
+		return decodeMP3(data)
+	default:
+		return nil, errors.New("unsupported audio format, only WAV and MP3 can be decoded")
+	}
+}
+
+func decodeWAV(data []byte) (*decodedAudio, error) {
+	var audioFormat, channels, bitsPerSample uint16
+	var sampleRate uint32
+	var pcm []byte
+
+	for offset := 12; offset+8 <= len(data); {
+		chunkID := string(data[offset : offset+4])
+		chunkSize := int(binary.LittleEndian.Uint32(data[offset+4 : offset+8]))
+		chunkStart := offset + 8
+		chunkEnd := min(chunkStart+chunkSize, len(data))
+
+		switch chunkID {
+		case "fmt ":
+			if chunkEnd-chunkStart < 16 {
+				return nil, errors.New("invalid WAV fmt chunk")
+			}
+			audioFormat = binary.LittleEndian.Uint16(data[chunkStart:])
+			channels = binary.LittleEndian.Uint16(data[chunkStart+2:])
+			sampleRate = binary.LittleEndian.Uint32(data[chunkStart+4:])
+			bitsPerSample = binary.LittleEndian.Uint16(data[chunkStart+14:])
+			// WAVE_FORMAT_EXTENSIBLE stores the actual format at the start of the sub format GUID
+			if audioFormat == 0xFFFE && chunkEnd-chunkStart >= 26 {
+				audioFormat = binary.LittleEndian.Uint16(data[chunkStart+24:])
+			}
+		case "data":
+			pcm = data[chunkStart:chunkEnd]
+		}
+
+		// Chunks are padded to an even size
+		offset = chunkStart + chunkSize + chunkSize%2
+	}
+
+	if channels == 0 || sampleRate == 0 || pcm == nil {
+		return nil, errors.New("invalid WAV file")
+	}
+
+	var codec string
+	var readSample func([]byte) float64
+	switch {
+	case audioFormat == 1 && bitsPerSample == 8:
+		codec = "pcm_u8"
+		readSample = func(b []byte) float64 { return (float64(b[0]) - 128) / 128 }
+	case audioFormat == 1 && bitsPerSample == 16:
+		codec = "pcm_s16le"
+		readSample = func(b []byte) float64 { return float64(int16(binary.LittleEndian.Uint16(b))) / (1 << 15) }
+	case audioFormat == 1 && bitsPerSample == 24:
+		codec = "pcm_s24le"
+		readSample = func(b []byte) float64 {
+			return float64(int32(uint32(b[0])<<8|uint32(b[1])<<16|uint32(b[2])<<24)>>8) / (1 << 23)
+		}
+	case audioFormat == 1 && bitsPerSample == 32:
+		codec = "pcm_s32le"
+		readSample = func(b []byte) float64 { return float64(int32(binary.LittleEndian.Uint32(b))) / (1 << 31) }
+	case audioFormat == 3 && bitsPerSample == 32:
+		codec = "pcm_f32le"
+		readSample = func(b []byte) float64 { return float64(math.Float32frombits(binary.LittleEndian.Uint32(b))) }
+	default:
+		return nil, fmt.Errorf("unsupported WAV format %d with %d bits per sample", audioFormat, bitsPerSample)
+	}
+
+	bytesPerSample := int(bitsPerSample / 8)
+	frameSize := bytesPerSample * int(channels)
+	samples := make([]int16, len(pcm)/frameSize)
+	for i := range samples {
+		var sum float64
+		for channel := 0; channel < int(channels); channel++ {
+			start := i*frameSize + channel*bytesPerSample
+			sum += readSample(pcm[start : start+bytesPerSample])
+		}
+		samples[i] = int16(max(-1, min(1, sum/float64(channels))) * math.MaxInt16)
+	}
+
+	return &decodedAudio{
+		container:  "wav",
+		codec:      codec,
+		sampleRate: int(sampleRate),
+		channels:   int(channels),
+		samples:    samples,
+	}, nil
+}
+
+func decodeMP3(data []byte) (*decodedAudio, error) {
+	decoder, err := mp3.NewDecoder(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode MP3: %w", err)
+	}
+
+	// The decoder always outputs interleaved 16-bit stereo
+	stereo, err := io.ReadAll(decoder)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode MP3: %w", err)
+	}
+
+	samples := make([]int16, len(stereo)/4)
+	for i := range samples {
+		left := int32(int16(binary.LittleEndian.Uint16(stereo[i*4:])))
+		right := int32(int16(binary.LittleEndian.Uint16(stereo[i*4+2:])))
+		samples[i] = int16((left + right) / 2)
+	}
+
+	return &decodedAudio{
+		container:  "mp3",
+		codec:      "mp3",
+		sampleRate: decoder.SampleRate(),
+		channels:   mp3Channels(data),
+		samples:    samples,
+	}, nil
+}
+
+// Reads the channel mode from the first frame header, skipping the ID3v2 tag if there is one
+func mp3Channels(data []byte) int {
+	offset := 0
+	if len(data) >= 10 && string(data[0:3]) == "ID3" {
+		tagSize := int(data[6]&0x7F)<<21 | int(data[7]&0x7F)<<14 | int(data[8]&0x7F)<<7 | int(data[9]&0x7F)
+		offset = 10 + tagSize
+	}
+
+	for ; offset+4 <= len(data); offset++ {
+		if data[offset] == 0xFF && data[offset+1]&0xE0 == 0xE0 {
+			if data[offset+3]>>6 == 3 {
+				return 1
+			}
+			return 2
+		}
+	}
+
+	return 2
+}
+
+func resample(samples []int16, fromRate int, toRate int) []int16 {
+	if fromRate == toRate || len(samples) == 0 {
+		return samples
+	}
+
+	result := make([]int16, int(int64(len(samples))*int64(toRate)/int64(fromRate)))
+	ratio := float64(fromRate) / float64(toRate)
+	for i := range result {
+		position := float64(i) * ratio
+		index := int(position)
+		if index+1 >= len(samples) {
+			result[i] = samples[len(samples)-1]
+			continue
+		}
+		fraction := position - float64(index)
+		result[i] = int16(float64(samples[index])*(1-fraction) + float64(samples[index+1])*fraction)
+	}
+
+	return result
+}
+
+func encodeWAV(samples []int16, sampleRate int) []byte {
+	dataSize := len(samples) * 2
+	buffer := bytes.NewBuffer(make([]byte, 0, 44+dataSize))
+
+	buffer.WriteString("RIFF")
+	binary.Write(buffer, binary.LittleEndian, uint32(36+dataSize))
+	buffer.WriteString("WAVEfmt ")
+	binary.Write(buffer, binary.LittleEndian, struct {
+		ChunkSize     uint32
+		AudioFormat   uint16
+		Channels      uint16
+		SampleRate    uint32
+		ByteRate      uint32
+		BlockAlign    uint16
+		BitsPerSample uint16
+	}{16, 1, 1, uint32(sampleRate), uint32(sampleRate * 2), 2, 16})
+	buffer.WriteString("data")
+	binary.Write(buffer, binary.LittleEndian, uint32(dataSize))
+	binary.Write(buffer, binary.LittleEndian, samples)
+
+	return buffer.Bytes()
+}
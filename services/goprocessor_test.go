@@ -0,0 +1,52 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"os"
+	"testing"
+)
+
+func TestGoProcessorProcessAudioStripsInputTags(t *testing.T) {
+	samples := make([]int16, 2*8000)
+	for i := range samples {
+		samples[i] = int16((i % 40) * 800)
+	}
+
+	// A WAV with a LIST INFO chunk and an ID3 chunk after the sample data
+	input := encodeWAV(samples, 8000)
+	input = append(input, riffChunk("LIST", append([]byte("INFO"), append(riffChunk("INAM", []byte("Secret Title\x00")), riffChunk("ICMT", []byte("Secret Comment\x00"))...)...))...)
+	input = append(input, riffChunk("id3 ", []byte("ID3Secret Artist"))...)
+	binary.LittleEndian.PutUint32(input[4:8], uint32(len(input)-8))
+
+	audio, err := NewGoProcessor().ProcessAudio(context.Background(), bytes.NewReader(input), nil, map[string]string{"publisher": "World Sounds"})
+	if err != nil {
+		t.Fatalf("failed to process audio: %v", err)
+	}
+	defer audio.Remove()
+
+	output, err := os.ReadFile(audio.FilePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, value := range []string{"Secret Title", "Secret Comment", "Secret Artist", "LIST", "id3 "} {
+		if bytes.Contains(output, []byte(value)) {
+			t.Errorf("output contains %q from the input", value)
+		}
+	}
+
+	if audio.DurationSeconds != 2 {
+		t.Errorf("duration is %d seconds, want 2", audio.DurationSeconds)
+	}
+}
+
+func riffChunk(id string, data []byte) []byte {
+	chunk := append([]byte(id), binary.LittleEndian.AppendUint32(nil, uint32(len(data)))...)
+	chunk = append(chunk, data...)
+	if len(data)%2 == 1 {
+		chunk = append(chunk, 0)
+	}
+	return chunk
+}
@@ -0,0 +1,43 @@
+package services
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+)
+
+const identiconGridSize = 5
+
+// Identicon renders a symmetric 5x5 grid SVG derived from the SHA-256 of the seed, so the same seed always produces
+// the same image. SVG scales to every avatar size, so a single file is enough
+func Identicon(seed []byte) []byte {
+	hash := sha256.Sum256(seed)
+
+	hue := binary.BigEndian.Uint16(hash[0:2]) % 360
+	foreground := fmt.Sprintf("hsl(%d,65%%,50%%)", hue)
+
+	var buffer bytes.Buffer
+	fmt.Fprintf(&buffer, `<svg xmlns="http://www.w3.org/2000/svg" viewBox="-1 -1 %d %d" shape-rendering="crispEdges">`, identiconGridSize+2, identiconGridSize+2)
+	fmt.Fprintf(&buffer, `<rect x="-1" y="-1" width="%d" height="%d" fill="#f0f0f0"/>`, identiconGridSize+2, identiconGridSize+2)
+
+	// Only the left half and the middle column come from the hash, the right half mirrors the left
+	columns := (identiconGridSize + 1) / 2
+	for y := 0; y < identiconGridSize; y++ {
+		for x := 0; x < columns; x++ {
+			bit := y*columns + x
+			if hash[2+bit/8]>>(bit%8)&1 == 0 {
+				continue
+			}
+
+			fmt.Fprintf(&buffer, `<rect x="%d" y="%d" width="1" height="1" fill="%s"/>`, x, y, foreground)
+			if mirrored := identiconGridSize - 1 - x; mirrored != x {
+				fmt.Fprintf(&buffer, `<rect x="%d" y="%d" width="1" height="1" fill="%s"/>`, mirrored, y, foreground)
+			}
+		}
+	}
+
+	buffer.WriteString(`</svg>`)
+
+	return buffer.Bytes()
+}
@@ -0,0 +1,74 @@
+package services
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"os"
+	"strconv"
+
+	_ "golang.org/x/image/webp"
+)
+
+var ErrImageTooLarge = errors.New("image is too large")
+var ErrImageUnsupported = errors.New("unsupported image format")
+
+// Square sizes in pixels every avatar is resized to
+var AvatarSizes = []int{64, 128, 512}
+
+const (
+	MaxImageBytes     = 25 << 20
+	MaxImageDimension = 16384
+	MaxImagePixels    = 64 << 20
+)
+
+type ProcessedImage struct {
+	Extension   string
+	ContentType string
+	Sizes       []ProcessedImageSize
+	dir         string
+}
+
+type ProcessedImageSize struct {
+	Size     int
+	FilePath string
+}
+
+func (image *ProcessedImage) Remove() {
+	os.RemoveAll(image.dir)
+}
+
+// Reads the whole image and checks its dimensions from the header, so decompression bombs are rejected before any
+// pixel data is decoded
+func readImage(reader io.Reader) ([]byte, image.Config, error) {
+	data, err := io.ReadAll(io.LimitReader(reader, MaxImageBytes+1))
+	if err != nil {
+		return nil, image.Config{}, fmt.Errorf("failed to read image: %w", err)
+	}
+	if len(data) > MaxImageBytes {
+		return nil, image.Config{}, fmt.Errorf("%w: more than %d bytes", ErrImageTooLarge, MaxImageBytes)
+	}
+
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, image.Config{}, fmt.Errorf("%w: %w", ErrImageUnsupported, err)
+	}
+
+	if config.Width <= 0 || config.Height <= 0 {
+		return nil, image.Config{}, fmt.Errorf("%w: invalid dimensions %dx%d", ErrImageUnsupported, config.Width, config.Height)
+	}
+	if config.Width > MaxImageDimension || config.Height > MaxImageDimension || int64(config.Width)*int64(config.Height) > MaxImagePixels {
+		return nil, image.Config{}, fmt.Errorf("%w: %dx%d", ErrImageTooLarge, config.Width, config.Height)
+	}
+
+	return data, config, nil
+}
+
+func imageSizeFileName(size int, extension string) string {
+	return "image-" + strconv.Itoa(size) + "." + extension
+}
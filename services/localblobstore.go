@@ -0,0 +1,378 @@
+package services
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const multipartDirName = ".multipart"
+
+// LocalBlobStore keeps every bucket as a directory under the root, for running without S3. Public buckets are served
+// by a static route, private buckets only through ServeHTTP with a signed URL. Content types aren't stored, they are
+// derived from the key extension
+type LocalBlobStore struct {
+	root          string
+	publicBaseURL *url.URL
+	signingKey    []byte
+}
+
+func NewLocalBlobStore() (*LocalBlobStore, error) {
+	root, ok := os.LookupEnv("BLOB_DIR")
+	if !ok {
+		root = "./blobs"
+	}
+	root, err := filepath.Abs(root)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve BLOB_DIR: %w", err)
+	}
+
+	// Defaults to the routes of the server itself, which listens on PORT
+	publicBaseURLString, ok := os.LookupEnv("BLOB_PUBLIC_URL")
+	if !ok {
+		port := os.Getenv("PORT")
+		if port == "" {
+			port = "3000"
+		}
+		publicBaseURLString = "http://localhost:" + port + "/blobs"
+	}
+	publicBaseURL, err := url.Parse(strings.TrimSuffix(publicBaseURLString, "/"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse BLOB_PUBLIC_URL: %w", err)
+	}
+
+	// Without a configured key, signed URLs stop working after a restart, which is fine for local development
+	signingKey := []byte(os.Getenv("BLOB_SIGNING_KEY"))
+	if len(signingKey) == 0 {
+		signingKey = make([]byte, 32)
+		if _, err := rand.Read(signingKey); err != nil {
+			return nil, fmt.Errorf("failed to read random bytes: %w", err)
+		}
+	}
+
+	for _, bucket := range buckets {
+		if err := os.MkdirAll(filepath.Join(root, string(bucket)), 0o755); err != nil {
+			return nil, fmt.Errorf("failed to create %s bucket directory: %w", bucket, err)
+		}
+	}
+
+	return &LocalBlobStore{
+		root:          root,
+		publicBaseURL: publicBaseURL,
+		signingKey:    signingKey,
+	}, nil
+}
+
+// PublicPath is the URL path the store's routes have to be mounted at
+func (store *LocalBlobStore) PublicPath() string {
+	return store.publicBaseURL.Path
+}
+
+func (store *LocalBlobStore) BucketDir(bucket Bucket) string {
+	return filepath.Join(store.root, string(bucket))
+}
+
+func (store *LocalBlobStore) filePath(bucket Bucket, key string) (string, error) {
+	if !filepath.IsLocal(key) || strings.HasPrefix(key, multipartDirName) {
+		return "", fmt.Errorf("invalid key: %s", key)
+	}
+	return filepath.Join(store.BucketDir(bucket), filepath.FromSlash(key)), nil
+}
+
+// Writes to a temporary file first, so readers never see a partially written object
+func writeFileAtomic(filePath string, reader io.Reader) error {
+	if err := os.MkdirAll(filepath.Dir(filePath), 0o755); err != nil {
+		return fmt.Errorf("failed to create directory: %w", err)
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(filePath), ".tmp-*")
+	if err != nil {
+		return fmt.Errorf("failed to create file: %w", err)
+	}
+	defer os.Remove(f.Name())
+
+	_, err = io.Copy(f, reader)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write file: %w", err)
+	}
+
+	if err := os.Rename(f.Name(), filePath); err != nil {
+		return fmt.Errorf("failed to rename file: %w", err)
+	}
+
+	return nil
+}
+
+func (store *LocalBlobStore) Put(ctx context.Context, bucket Bucket, key string, reader io.Reader, size int64, contentType string) error {
+	filePath, err := store.filePath(bucket, key)
+	if err != nil {
+		return err
+	}
+
+	if err := writeFileAtomic(filePath, io.LimitReader(reader, size)); err != nil {
+		return fmt.Errorf("failed to put %s object: %w", bucket, err)
+	}
+
+	return nil
+}
+
+func (store *LocalBlobStore) Get(ctx context.Context, bucket Bucket, key string) (io.ReadCloser, error) {
+	filePath, err := store.filePath(bucket, key)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(filePath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrBlobNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get %s object: %w", bucket, err)
+	}
+
+	return f, nil
+}
+
+func (store *LocalBlobStore) Delete(ctx context.Context, bucket Bucket, key string) error {
+	filePath, err := store.filePath(bucket, key)
+	if err != nil {
+		return err
+	}
+
+	err = os.Remove(filePath)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to remove %s object: %w", bucket, err)
+	}
+
+	return nil
+}
+
+func (store *LocalBlobStore) Stat(ctx context.Context, bucket Bucket, key string) (*BlobInfo, error) {
+	filePath, err := store.filePath(bucket, key)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := os.Stat(filePath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrBlobNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat %s object: %w", bucket, err)
+	}
+
+	contentType := mime.TypeByExtension(path.Ext(key))
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+
+	return &BlobInfo{
+		Size:         info.Size(),
+		ContentType:  contentType,
+		LastModified: info.ModTime(),
+	}, nil
+}
+
+func (store *LocalBlobStore) List(ctx context.Context, bucket Bucket, fn func(key string, info *BlobInfo) error) error {
+	bucketDir := store.BucketDir(bucket)
+
+	err := filepath.WalkDir(bucketDir, func(filePath string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		// Skip in progress multipart uploads and atomic writes
+		if strings.HasPrefix(entry.Name(), ".") && filePath != bucketDir {
+			if entry.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if entry.IsDir() {
+			return nil
+		}
+
+		relativePath, err := filepath.Rel(bucketDir, filePath)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(relativePath)
+
+		info, err := store.Stat(ctx, bucket, key)
+		if err != nil {
+			return err
+		}
+
+		return fn(key, info)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to list %s objects: %w", bucket, err)
+	}
+
+	return nil
+}
+
+func (store *LocalBlobStore) PublicURL(bucket Bucket, key string) string {
+	return store.publicBaseURL.JoinPath(string(bucket), key).String()
+}
+
+func (store *LocalBlobStore) signature(bucket Bucket, key string, expires string) string {
+	mac := hmac.New(sha256.New, store.signingKey)
+	fmt.Fprintf(mac, "%s/%s\n%s", bucket, key, expires)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (store *LocalBlobStore) SignedURL(ctx context.Context, bucket Bucket, key string, expiry time.Duration) (string, error) {
+	expires := strconv.FormatInt(time.Now().Add(expiry).Unix(), 10)
+
+	signedURL := store.publicBaseURL.JoinPath("signed", string(bucket), key)
+	signedURL.RawQuery = url.Values{
+		"expires":   {expires},
+		"signature": {store.signature(bucket, key, expires)},
+	}.Encode()
+
+	return signedURL.String(), nil
+}
+
+// ServeHTTP serves objects of any bucket at /<bucket>/<key> to requests with a valid, unexpired signature
+func (store *LocalBlobStore) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	bucketName, key, ok := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	bucket := Bucket(bucketName)
+	if !ok || !slices.Contains(buckets, bucket) {
+		http.NotFound(w, r)
+		return
+	}
+
+	expires := r.URL.Query().Get("expires")
+	expiresUnix, err := strconv.ParseInt(expires, 10, 64)
+	signature := r.URL.Query().Get("signature")
+	if err != nil || time.Now().Unix() > expiresUnix || !hmac.Equal([]byte(signature), []byte(store.signature(bucket, key, expires))) {
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
+	}
+
+	filePath, err := store.filePath(bucket, key)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	http.ServeFile(w, r, filePath)
+}
+
+func (store *LocalBlobStore) multipartDir(uploadID string) (string, error) {
+	if uploadID == "" || !filepath.IsLocal(uploadID) || strings.ContainsAny(uploadID, `/\`) {
+		return "", fmt.Errorf("invalid upload id: %s", uploadID)
+	}
+	return filepath.Join(store.BucketDir(BucketUpload), multipartDirName, uploadID), nil
+}
+
+func (store *LocalBlobStore) CreateMultipart(ctx context.Context, key string) (string, error) {
+	uploadIDBytes := make([]byte, 16)
+	if _, err := rand.Read(uploadIDBytes); err != nil {
+		return "", fmt.Errorf("failed to read random bytes: %w", err)
+	}
+	uploadID := hex.EncodeToString(uploadIDBytes)
+
+	dir, err := store.multipartDir(uploadID)
+	if err != nil {
+		return "", err
+	}
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", fmt.Errorf("failed to create multipart upload: %w", err)
+	}
+
+	return uploadID, nil
+}
+
+func (store *LocalBlobStore) PutPart(ctx context.Context, key string, uploadID string, partNumber int, reader io.Reader, size int64) error {
+	dir, err := store.multipartDir(uploadID)
+	if err != nil {
+		return err
+	}
+
+	if _, err := os.Stat(dir); err != nil {
+		return fmt.Errorf("failed to find multipart upload: %w", err)
+	}
+
+	if err := writeFileAtomic(filepath.Join(dir, fmt.Sprintf("%05d", partNumber)), io.LimitReader(reader, size)); err != nil {
+		return fmt.Errorf("failed to put object part: %w", err)
+	}
+
+	return nil
+}
+
+func (store *LocalBlobStore) CompleteMultipart(ctx context.Context, key string, uploadID string) error {
+	dir, err := store.multipartDir(uploadID)
+	if err != nil {
+		return err
+	}
+
+	// Part file names are zero padded, so the directory order is the part order
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return fmt.Errorf("failed to list object parts: %w", err)
+	}
+
+	readers := []io.Reader{}
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+
+		part, err := os.Open(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return fmt.Errorf("failed to open object part: %w", err)
+		}
+		defer part.Close()
+
+		readers = append(readers, part)
+	}
+
+	filePath, err := store.filePath(BucketUpload, key)
+	if err != nil {
+		return err
+	}
+
+	if err := writeFileAtomic(filePath, io.MultiReader(readers...)); err != nil {
+		return fmt.Errorf("failed to complete multipart upload: %w", err)
+	}
+
+	return os.RemoveAll(dir)
+}
+
+func (store *LocalBlobStore) AbortMultipart(ctx context.Context, key string, uploadID string) error {
+	dir, err := store.multipartDir(uploadID)
+	if err != nil {
+		return err
+	}
+
+	if err := os.RemoveAll(dir); err != nil {
+		return fmt.Errorf("failed to abort multipart upload: %w", err)
+	}
+
+	return nil
+}
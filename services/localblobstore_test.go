@@ -0,0 +1,39 @@
+package services
+
+import (
+	"os"
+	"testing"
+)
+
+func TestNewLocalBlobStorePublicURL(t *testing.T) {
+	tests := []struct {
+		name          string
+		port          string
+		publicURL     string
+		wantPublicURL string
+	}{
+		{"default port", "", "", "http://localhost:3000/blobs/images/avatar.png"},
+		{"port", "8081", "", "http://localhost:8081/blobs/images/avatar.png"},
+		{"public url", "8081", "https://cdn.example.com/files/", "https://cdn.example.com/files/images/avatar.png"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Setenv("BLOB_DIR", t.TempDir())
+			t.Setenv("PORT", test.port)
+			t.Setenv("BLOB_PUBLIC_URL", test.publicURL)
+			if test.publicURL == "" {
+				os.Unsetenv("BLOB_PUBLIC_URL")
+			}
+
+			store, err := NewLocalBlobStore()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if publicURL := store.PublicURL(BucketImage, "avatar.png"); publicURL != test.wantPublicURL {
+				t.Errorf("got %s, want %s", publicURL, test.wantPublicURL)
+			}
+		})
+	}
+}
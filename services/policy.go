@@ -0,0 +1,239 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/big"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+type UploadPolicy struct {
+	AllowedContainers  []string `json:"allowed_containers"`
+	AllowedCodecs      []string `json:"allowed_codecs"`
+	MinDurationSeconds float64  `json:"min_duration_seconds"`
+	MaxDurationSeconds float64  `json:"max_duration_seconds"`
+	MaxSizeBytes       int64    `json:"max_size_bytes"`
+	MaxChannels        int      `json:"max_channels"`
+	MinSampleRate      int      `json:"min_sample_rate"`
+	MaxSampleRate      int      `json:"max_sample_rate"`
+}
+
+type UploadPolicyViolation struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+type AudioProbe struct {
+	Containers      []string
+	Codec           string
+	DurationSeconds float64
+	SampleRate      int
+	Channels        int
+	HasVideo        bool
+}
+
+var ErrUploadTooLarge = errors.New("upload exceeds the maximum size")
+
+var (
+	probeInputRegex       = regexp.MustCompile(`Input #0, (.+?), from`)
+	probeDurationRegex    = regexp.MustCompile(`Duration: (\d+):(\d+):(\d+(?:\.\d+)?)`)
+	probeAudioStreamRegex = regexp.MustCompile(`Stream #0:\d+.*?: Audio: (\w+).*?, (\d+) Hz, ([^,]+),`)
+	probeVideoStreamRegex = regexp.MustCompile(`Stream #0:\d+.*?: Video: .*`)
+	probeChannelsRegex    = regexp.MustCompile(`^(\d+) channels`)
+)
+
+var channelLayouts = map[string]int{
+	"mono":      1,
+	"stereo":    2,
+	"2.1":       3,
+	"3.0":       3,
+	"quad":      4,
+	"4.0":       4,
+	"5.0":       5,
+	"5.0(side)": 5,
+	"5.1":       6,
+	"5.1(side)": 6,
+	"6.1":       7,
+	"7.1":       8,
+}
+
+func DefaultUploadPolicy() UploadPolicy {
+	return UploadPolicy{
+		AllowedContainers:  []string{"mp3", "wav", "ogg", "flac", "aiff", "aac", "mov", "mp4", "m4a", "matroska", "webm"},
+		AllowedCodecs:      []string{"mp3", "aac", "opus", "vorbis", "flac", "alac", "pcm_s16le", "pcm_s24le", "pcm_s32le", "pcm_f32le", "pcm_s16be", "pcm_s24be"},
+		MinDurationSeconds: 1,
+		MaxDurationSeconds: 600,
+		MaxSizeBytes:       60 << 20,
+		MaxChannels:        2,
+		MinSampleRate:      8000,
+		MaxSampleRate:      96000,
+	}
+}
+
+// Starts from the default policy and overrides it with the JSON file at UPLOAD_POLICY_FILE, then with the JSON in
+// UPLOAD_POLICY
+func LoadUploadPolicy() (UploadPolicy, error) {
+	policy := DefaultUploadPolicy()
+
+	if policyFile, ok := os.LookupEnv("UPLOAD_POLICY_FILE"); ok {
+		policyBytes, err := os.ReadFile(policyFile)
+		if err != nil {
+			return policy, fmt.Errorf("failed to read upload policy file: %w", err)
+		}
+
+		if err := json.Unmarshal(policyBytes, &policy); err != nil {
+			return policy, fmt.Errorf("failed to parse upload policy file: %w", err)
+		}
+	}
+
+	if policyJSON, ok := os.LookupEnv("UPLOAD_POLICY"); ok {
+		if err := json.Unmarshal([]byte(policyJSON), &policy); err != nil {
+			return policy, fmt.Errorf("failed to parse UPLOAD_POLICY environment variable: %w", err)
+		}
+	}
+
+	if policy.MaxSizeBytes <= 0 || policy.MaxDurationSeconds <= 0 || policy.MaxChannels <= 0 {
+		return policy, errors.New("upload policy limits must be positive")
+	}
+
+	return policy, nil
+}
+
+func (policy UploadPolicy) Check(probe *AudioProbe, sizeBytes int64) []UploadPolicyViolation {
+	violations := []UploadPolicyViolation{}
+
+	if sizeBytes > policy.MaxSizeBytes {
+		violations = append(violations, UploadPolicyViolation{"max_size", fmt.Sprintf("size must be less than or equal to %d bytes", policy.MaxSizeBytes)})
+	}
+
+	if !slices.ContainsFunc(probe.Containers, func(container string) bool { return slices.Contains(policy.AllowedContainers, container) }) {
+		violations = append(violations, UploadPolicyViolation{"container", fmt.Sprintf("container %s is not allowed", strings.Join(probe.Containers, ","))})
+	}
+
+	if probe.HasVideo {
+		violations = append(violations, UploadPolicyViolation{"video", "video streams are not allowed"})
+	}
+
+	if probe.Codec == "" {
+		violations = append(violations, UploadPolicyViolation{"codec", "no audio stream found"})
+		return violations
+	}
+
+	if !slices.Contains(policy.AllowedCodecs, probe.Codec) {
+		violations = append(violations, UploadPolicyViolation{"codec", fmt.Sprintf("codec %s is not allowed", probe.Codec)})
+	}
+
+	if probe.DurationSeconds < policy.MinDurationSeconds {
+		violations = append(violations, UploadPolicyViolation{"min_duration", fmt.Sprintf("duration must be at least %v seconds", policy.MinDurationSeconds)})
+	}
+
+	if probe.DurationSeconds > policy.MaxDurationSeconds {
+		violations = append(violations, UploadPolicyViolation{"max_duration", fmt.Sprintf("duration must be at most %v seconds", policy.MaxDurationSeconds)})
+	}
+
+	if probe.Channels < 1 || probe.Channels > policy.MaxChannels {
+		violations = append(violations, UploadPolicyViolation{"channels", fmt.Sprintf("channel count must be between 1 and %d", policy.MaxChannels)})
+	}
+
+	if probe.SampleRate < policy.MinSampleRate || probe.SampleRate > policy.MaxSampleRate {
+		violations = append(violations, UploadPolicyViolation{"sample_rate", fmt.Sprintf("sample rate must be between %d and %d Hz", policy.MinSampleRate, policy.MaxSampleRate)})
+	}
+
+	return violations
+}
+
+// Copies the upload into a temporary file so it can be probed before transcoding. Returns ErrUploadTooLarge as soon as
+// more than maxSizeBytes are read
+func BufferUpload(reader io.Reader, maxSizeBytes int64) (string, int64, error) {
+	number, err := rand.Int(rand.Reader, big.NewInt(math.MaxInt64))
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to generate random number: %w", err)
+	}
+
+	targetFileName := filepath.Join(os.TempDir(), fmt.Sprintf("upload-%d", number.Uint64()))
+
+	f, err := os.Create(targetFileName)
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to create file: %w", err)
+	}
+	defer f.Close()
+
+	size, err := io.Copy(f, io.LimitReader(reader, maxSizeBytes+1))
+	if err != nil {
+		os.Remove(targetFileName)
+		return "", 0, fmt.Errorf("failed to write file: %w", err)
+	}
+
+	if size > maxSizeBytes {
+		os.Remove(targetFileName)
+		return "", 0, ErrUploadTooLarge
+	}
+
+	return targetFileName, size, nil
+}
+
+func (sandbox *FFmpegSandbox) ProbeAudio(ctx context.Context, filePath string) (*AudioProbe, error) {
+	dir, err := newJobDir()
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	// Without an output file ffmpeg prints the input information and exits with an error, which is expected
+	var stderr bytes.Buffer
+	err = sandbox.run(ctx, dir, nil, nil, &stderr, "-hide_banner", "-i", filePath)
+	if errors.Is(err, ErrFFmpegKilled) || ctx.Err() != nil {
+		return nil, fmt.Errorf("failed to probe audio: %w", err)
+	}
+	stderrBytes := stderr.Bytes()
+
+	inputMatch := probeInputRegex.FindSubmatch(stderrBytes)
+	if len(inputMatch) != 2 {
+		return nil, fmt.Errorf("failed to find input format: `%v`", string(stderrBytes))
+	}
+
+	probe := &AudioProbe{
+		Containers: strings.Split(string(inputMatch[1]), ","),
+	}
+
+	durationMatch := probeDurationRegex.FindSubmatch(stderrBytes)
+	if len(durationMatch) == 4 {
+		hours, _ := strconv.ParseFloat(string(durationMatch[1]), 64)
+		minutes, _ := strconv.ParseFloat(string(durationMatch[2]), 64)
+		seconds, _ := strconv.ParseFloat(string(durationMatch[3]), 64)
+		probe.DurationSeconds = hours*3600 + minutes*60 + seconds
+	}
+
+	audioMatch := probeAudioStreamRegex.FindSubmatch(stderrBytes)
+	if len(audioMatch) == 4 {
+		probe.Codec = string(audioMatch[1])
+		probe.SampleRate, _ = strconv.Atoi(string(audioMatch[2]))
+
+		channelLayout := strings.TrimSpace(string(audioMatch[3]))
+		if channels, ok := channelLayouts[channelLayout]; ok {
+			probe.Channels = channels
+		} else if channelsMatch := probeChannelsRegex.FindStringSubmatch(channelLayout); len(channelsMatch) == 2 {
+			probe.Channels, _ = strconv.Atoi(channelsMatch[1])
+		}
+	}
+
+	for _, videoMatch := range probeVideoStreamRegex.FindAll(stderrBytes, -1) {
+		// Cover art embedded in audio files shows up as a video stream
+		if !strings.Contains(string(videoMatch), "(attached pic)") {
+			probe.HasVideo = true
+		}
+	}
+
+	return probe, nil
+}
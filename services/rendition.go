@@ -0,0 +1,68 @@
+package services
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const DefaultRenditionProfiles = "opus:48,aac:96,mp3:128"
+
+type RenditionProfile struct {
+	Codec       string
+	BitrateKbps int
+	Encoder     string
+	Format      string
+	Extension   string
+	ContentType string
+}
+
+type ProcessedRendition struct {
+	Profile  RenditionProfile
+	FilePath string
+}
+
+var renditionCodecs = map[string]RenditionProfile{
+	"opus": {Codec: "opus", Encoder: "libopus", Format: "ogg", Extension: "opus", ContentType: "audio/ogg; codecs=opus"},
+	"aac":  {Codec: "aac", Encoder: "aac", Format: "ipod", Extension: "m4a", ContentType: "audio/mp4; codecs=mp4a.40.2"},
+	"mp3":  {Codec: "mp3", Encoder: "libmp3lame", Format: "mp3", Extension: "mp3", ContentType: "audio/mpeg"},
+}
+
+// Parses a comma separated list of codec:kbps pairs, such as "opus:48,aac:96"
+func ParseRenditionProfiles(value string) ([]RenditionProfile, error) {
+	profiles := []RenditionProfile{}
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		codec, bitrateString, ok := strings.Cut(entry, ":")
+		if !ok {
+			return nil, fmt.Errorf("rendition must be in the codec:kbps format: %s", entry)
+		}
+
+		profile, ok := renditionCodecs[codec]
+		if !ok {
+			return nil, fmt.Errorf("unsupported rendition codec: %s", codec)
+		}
+
+		bitrate, err := strconv.Atoi(strings.TrimSuffix(bitrateString, "k"))
+		if err != nil || bitrate <= 0 {
+			return nil, fmt.Errorf("rendition bitrate must be a positive integer: %s", bitrateString)
+		}
+		profile.BitrateKbps = bitrate
+
+		profiles = append(profiles, profile)
+	}
+
+	return profiles, nil
+}
+
+func (profile RenditionProfile) Name() string {
+	return fmt.Sprintf("%s-%dk", profile.Codec, profile.BitrateKbps)
+}
+
+func (profile RenditionProfile) ffmpegArgs(targetFileName string) []string {
+	return []string{"-vn", "-c:a", profile.Encoder, "-b:a", fmt.Sprintf("%dk", profile.BitrateKbps), "-f", profile.Format, targetFileName}
+}
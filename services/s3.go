@@ -4,7 +4,10 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io"
+	"net/url"
 	"os"
+	"time"
 
 	"github.com/minio/minio-go/v7"
 	"github.com/minio/minio-go/v7/pkg/credentials"
@@ -12,8 +15,8 @@ import (
 
 type S3Service struct {
 	client         *minio.Client
-	mp3BucketName  string
-	webpBucketName string
+	presignClient  *minio.Client
+	bucketNames    map[Bucket]string
 	publicEndpoint string
 }
 
@@ -45,6 +48,27 @@ func NewS3Service() (*S3Service, error) {
 		return nil, fmt.Errorf("failed to create MinIO client: %w", err)
 	}
 
+	region, ok := os.LookupEnv("S3_REGION")
+	if !ok {
+		region = "us-east-1"
+	}
+
+	// Presigned URLs are handed to clients, so they must be signed for the public host. Setting the region avoids the
+	// bucket location lookup, which the public endpoint may not be reachable for
+	publicEndpointURL, err := url.Parse(publicEndpoint)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse S3_PUBLIC_ENDPOINT: %w", err)
+	}
+
+	presignClient, err := minio.New(publicEndpointURL.Host, &minio.Options{
+		Creds:  credentials.NewStaticV4(accessKeyID, secretAccessKey, ""),
+		Secure: publicEndpointURL.Scheme == "https",
+		Region: region,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create MinIO presign client: %w", err)
+	}
+
 	mp3BucketName, ok := os.LookupEnv("S3_MP3_BUCKET")
 	if !ok {
 		return nil, errors.New("S3_MP3_BUCKET environment variable not set")
@@ -55,28 +79,193 @@ func NewS3Service() (*S3Service, error) {
 		return nil, errors.New("S3_WEBP_BUCKET environment variable not set")
 	}
 
+	privateAudioBucketName, err := derivedBucketName(client, "S3_PRIVATE_AUDIO_BUCKET", mp3BucketName+"-private", region)
+	if err != nil {
+		return nil, err
+	}
+
+	uploadBucketName, err := derivedBucketName(client, "S3_UPLOAD_BUCKET", mp3BucketName+"-uploads", region)
+	if err != nil {
+		return nil, err
+	}
+
 	return &S3Service{
-		client:         client,
-		mp3BucketName:  mp3BucketName,
-		webpBucketName: webpBucketName,
+		client:        client,
+		presignClient: presignClient,
+		bucketNames: map[Bucket]string{
+			BucketAudio:        mp3BucketName,
+			BucketPrivateAudio: privateAudioBucketName,
+			BucketImage:        webpBucketName,
+			BucketUpload:       uploadBucketName,
+		},
 		publicEndpoint: publicEndpoint,
 	}, nil
 }
 
-func (s3Service S3Service) UploadMP3(ctx context.Context, filePath string, objectName string) (string, error) {
-	info, err := s3Service.client.FPutObject(ctx, s3Service.mp3BucketName, objectName, filePath, minio.PutObjectOptions{ContentType: "audio/mpeg"})
+// Buckets added after the first deployments default to a name derived from an existing bucket and are created when
+// missing, so upgrading doesn't require new configuration. New buckets are private
+func derivedBucketName(client *minio.Client, envName string, defaultName string, region string) (string, error) {
+	if bucketName, ok := os.LookupEnv(envName); ok {
+		return bucketName, nil
+	}
+
+	ctx := context.Background()
+	exists, err := client.BucketExists(ctx, defaultName)
+	if err != nil {
+		return "", fmt.Errorf("failed to check bucket %s for unset %s: %w", defaultName, envName, err)
+	}
+	if !exists {
+		err := client.MakeBucket(ctx, defaultName, minio.MakeBucketOptions{Region: region})
+		if err != nil {
+			return "", fmt.Errorf("failed to create bucket %s for unset %s: %w", defaultName, envName, err)
+		}
+	}
+
+	return defaultName, nil
+}
+
+func (s3Service S3Service) Put(ctx context.Context, bucket Bucket, key string, reader io.Reader, size int64, contentType string) error {
+	_, err := s3Service.client.PutObject(ctx, s3Service.bucketNames[bucket], key, reader, size, minio.PutObjectOptions{ContentType: contentType})
+	if err != nil {
+		return fmt.Errorf("failed to put %s object: %w", bucket, err)
+	}
+
+	return nil
+}
+
+func (s3Service S3Service) Get(ctx context.Context, bucket Bucket, key string) (io.ReadCloser, error) {
+	object, err := s3Service.client.GetObject(ctx, s3Service.bucketNames[bucket], key, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get %s object: %w", bucket, err)
+	}
+
+	return object, nil
+}
+
+func (s3Service S3Service) Delete(ctx context.Context, bucket Bucket, key string) error {
+	err := s3Service.client.RemoveObject(ctx, s3Service.bucketNames[bucket], key, minio.RemoveObjectOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to remove %s object: %w", bucket, err)
+	}
+
+	return nil
+}
+
+func (s3Service S3Service) Stat(ctx context.Context, bucket Bucket, key string) (*BlobInfo, error) {
+	info, err := s3Service.client.StatObject(ctx, s3Service.bucketNames[bucket], key, minio.StatObjectOptions{})
+	if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+		return nil, ErrBlobNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat %s object: %w", bucket, err)
+	}
+
+	return &BlobInfo{
+		Size:         info.Size,
+		ContentType:  info.ContentType,
+		LastModified: info.LastModified,
+	}, nil
+}
+
+func (s3Service S3Service) List(ctx context.Context, bucket Bucket, fn func(key string, info *BlobInfo) error) error {
+	// Canceling stops the listing goroutine when fn returns early
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	for object := range s3Service.client.ListObjects(ctx, s3Service.bucketNames[bucket], minio.ListObjectsOptions{Recursive: true}) {
+		if object.Err != nil {
+			return fmt.Errorf("failed to list %s objects: %w", bucket, object.Err)
+		}
+
+		err := fn(object.Key, &BlobInfo{
+			Size:         object.Size,
+			ContentType:  object.ContentType,
+			LastModified: object.LastModified,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s3Service S3Service) PublicURL(bucket Bucket, key string) string {
+	return fmt.Sprintf("%s/%s/%s", s3Service.publicEndpoint, s3Service.bucketNames[bucket], key)
+}
+
+func (s3Service S3Service) SignedURL(ctx context.Context, bucket Bucket, key string, expiry time.Duration) (string, error) {
+	presignedURL, err := s3Service.presignClient.PresignedGetObject(ctx, s3Service.bucketNames[bucket], key, expiry, nil)
 	if err != nil {
-		return "", fmt.Errorf("failed to put MP3 object: %w", err)
+		return "", fmt.Errorf("failed to presign %s object: %w", bucket, err)
 	}
 
-	return fmt.Sprintf("%s/%s/%s", s3Service.publicEndpoint, s3Service.mp3BucketName, info.Key), nil
+	return presignedURL.String(), nil
 }
 
-func (s3Service S3Service) UploadWebP(ctx context.Context, filePath string, objectName string) (string, error) {
-	info, err := s3Service.client.FPutObject(ctx, s3Service.webpBucketName, objectName, filePath, minio.PutObjectOptions{ContentType: "image/webp"})
+func (s3Service S3Service) CreateMultipart(ctx context.Context, key string) (string, error) {
+	core := minio.Core{Client: s3Service.client}
+
+	uploadID, err := core.NewMultipartUpload(ctx, s3Service.bucketNames[BucketUpload], key, minio.PutObjectOptions{ContentType: "application/octet-stream"})
+	if err != nil {
+		return "", fmt.Errorf("failed to create multipart upload: %w", err)
+	}
+
+	return uploadID, nil
+}
+
+func (s3Service S3Service) PutPart(ctx context.Context, key string, uploadID string, partNumber int, reader io.Reader, size int64) error {
+	core := minio.Core{Client: s3Service.client}
+
+	_, err := core.PutObjectPart(ctx, s3Service.bucketNames[BucketUpload], key, uploadID, partNumber, reader, size, minio.PutObjectPartOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to put object part: %w", err)
+	}
+
+	return nil
+}
+
+func (s3Service S3Service) CompleteMultipart(ctx context.Context, key string, uploadID string) error {
+	core := minio.Core{Client: s3Service.client}
+	bucketName := s3Service.bucketNames[BucketUpload]
+
+	parts := []minio.CompletePart{}
+	partNumberMarker := 0
+	for {
+		result, err := core.ListObjectParts(ctx, bucketName, key, uploadID, partNumberMarker, 1000)
+		if err != nil {
+			return fmt.Errorf("failed to list object parts: %w", err)
+		}
+
+		for _, part := range result.ObjectParts {
+			parts = append(parts, minio.CompletePart{PartNumber: part.PartNumber, ETag: part.ETag})
+		}
+
+		if !result.IsTruncated {
+			break
+		}
+		partNumberMarker = result.NextPartNumberMarker
+	}
+
+	_, err := core.CompleteMultipartUpload(ctx, bucketName, key, uploadID, parts, minio.PutObjectOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to complete multipart upload: %w", err)
+	}
+
+	return nil
+}
+
+func (s3Service S3Service) AbortMultipart(ctx context.Context, key string, uploadID string) error {
+	core := minio.Core{Client: s3Service.client}
+
+	err := core.AbortMultipartUpload(ctx, s3Service.bucketNames[BucketUpload], key, uploadID)
+	// Aborting is idempotent, like removing the parts directory of the local store
+	if minio.ToErrorResponse(err).Code == "NoSuchUpload" {
+		return nil
+	}
 	if err != nil {
-		return "", fmt.Errorf("failed to put WebP object: %w", err)
+		return fmt.Errorf("failed to abort multipart upload: %w", err)
 	}
 
-	return fmt.Sprintf("%s/%s/%s", s3Service.publicEndpoint, s3Service.webpBucketName, info.Key), nil
+	return nil
 }
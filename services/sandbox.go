@@ -0,0 +1,151 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+var ErrFFmpegKilled = errors.New("ffmpeg was killed")
+
+var (
+	ffmpegJobsMetrics       = expvar.NewMap("ffmpeg_jobs")
+	ffmpegJobsKilledMetrics = expvar.NewMap("ffmpeg_jobs_killed")
+)
+
+type FFmpegLimits struct {
+	CPUSeconds        uint64
+	AddressSpaceBytes uint64
+	FileSizeBytes     uint64
+}
+
+type FFmpegSandbox struct {
+	binaryPath string
+	semaphore  chan struct{}
+	timeout    time.Duration
+	limits     FFmpegLimits
+}
+
+func NewFFmpegSandbox() (*FFmpegSandbox, error) {
+	binaryPath, err := filepath.Abs("./ffmpeg")
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve ffmpeg path: %w", err)
+	}
+
+	maxJobs, err := lookupEnvUint("FFMPEG_MAX_JOBS", 4)
+	if err != nil {
+		return nil, err
+	}
+	if maxJobs == 0 {
+		return nil, errors.New("FFMPEG_MAX_JOBS environment variable must be positive")
+	}
+
+	timeout := 2 * time.Minute
+	if timeoutString := os.Getenv("FFMPEG_TIMEOUT"); timeoutString != "" {
+		timeout, err = time.ParseDuration(timeoutString)
+		if err != nil || timeout <= 0 {
+			return nil, fmt.Errorf("FFMPEG_TIMEOUT environment variable must be a positive duration: %s", timeoutString)
+		}
+	}
+
+	cpuSeconds, err := lookupEnvUint("FFMPEG_MAX_CPU_SECONDS", 60)
+	if err != nil {
+		return nil, err
+	}
+
+	addressSpaceBytes, err := lookupEnvUint("FFMPEG_MAX_ADDRESS_SPACE_BYTES", 1<<30)
+	if err != nil {
+		return nil, err
+	}
+
+	fileSizeBytes, err := lookupEnvUint("FFMPEG_MAX_FILE_SIZE_BYTES", 256<<20)
+	if err != nil {
+		return nil, err
+	}
+
+	return &FFmpegSandbox{
+		binaryPath: binaryPath,
+		semaphore:  make(chan struct{}, maxJobs),
+		timeout:    timeout,
+		limits: FFmpegLimits{
+			CPUSeconds:        cpuSeconds,
+			AddressSpaceBytes: addressSpaceBytes,
+			FileSizeBytes:     fileSizeBytes,
+		},
+	}, nil
+}
+
+func lookupEnvUint(key string, defaultValue uint64) (uint64, error) {
+	valueString, ok := os.LookupEnv(key)
+	if !ok {
+		return defaultValue, nil
+	}
+
+	value, err := strconv.ParseUint(valueString, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%s environment variable must be a non-negative integer: %s", key, valueString)
+	}
+
+	return value, nil
+}
+
+// Every job gets its own temporary directory, removed by the caller once the outputs are no longer needed
+func newJobDir() (string, error) {
+	dir, err := os.MkdirTemp("", "media-job-*")
+	if err != nil {
+		return "", fmt.Errorf("failed to create job directory: %w", err)
+	}
+	return dir, nil
+}
+
+func (sandbox *FFmpegSandbox) run(ctx context.Context, dir string, stdin io.Reader, stdout io.Writer, stderr io.Writer, args ...string) error {
+	select {
+	case sandbox.semaphore <- struct{}{}:
+	case <-ctx.Done():
+		ffmpegJobsMetrics.Add("canceled_waiting", 1)
+		return ctx.Err()
+	}
+	defer func() { <-sandbox.semaphore }()
+
+	jobCtx, cancel := context.WithTimeout(ctx, sandbox.timeout)
+	defer cancel()
+
+	cmd := sandbox.command(jobCtx, args...)
+	cmd.Dir = dir
+	cmd.Env = []string{"TMPDIR=" + dir}
+	cmd.Stdin = stdin
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("failed to start ffmpeg: %w", err)
+	}
+	ffmpegJobsMetrics.Add("started", 1)
+
+	err := cmd.Wait()
+	if err == nil {
+		ffmpegJobsMetrics.Add("succeeded", 1)
+		return nil
+	}
+	ffmpegJobsMetrics.Add("failed", 1)
+
+	reason := killReason(cmd.ProcessState)
+	if ctx.Err() != nil {
+		reason = "canceled"
+	} else if jobCtx.Err() != nil {
+		reason = "timeout"
+	}
+
+	if reason != "" {
+		ffmpegJobsKilledMetrics.Add(reason, 1)
+		return fmt.Errorf("%w (%s): %w", ErrFFmpegKilled, reason, err)
+	}
+
+	return err
+}
@@ -0,0 +1,104 @@
+//go:build linux
+
+package services
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// First argument of the binary when it is re-executed to start ffmpeg
+const ffmpegShimArg = "ffmpeg-shim"
+
+// The standard library can't set resource limits between fork and exec, so ffmpeg is started by re-executing this
+// binary, which sets the limits and then replaces itself with ffmpeg. This way ffmpeg never runs without them
+func (sandbox *FFmpegSandbox) command(ctx context.Context, args ...string) *exec.Cmd {
+	shimArgs := []string{
+		ffmpegShimArg,
+		strconv.FormatUint(sandbox.limits.CPUSeconds, 10),
+		strconv.FormatUint(sandbox.limits.AddressSpaceBytes, 10),
+		strconv.FormatUint(sandbox.limits.FileSizeBytes, 10),
+		sandbox.binaryPath,
+	}
+	return exec.CommandContext(ctx, "/proc/self/exe", append(shimArgs, args...)...)
+}
+
+// Has to be called first thing in main and in TestMain of tests running ffmpeg. It only returns when the process
+// wasn't started as the ffmpeg shim
+func RunFFmpegShim() {
+	if len(os.Args) < 6 || os.Args[1] != ffmpegShimArg {
+		return
+	}
+
+	var limits FFmpegLimits
+	for i, limit := range []*uint64{&limits.CPUSeconds, &limits.AddressSpaceBytes, &limits.FileSizeBytes} {
+		value, err := strconv.ParseUint(os.Args[2+i], 10, 64)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "invalid ffmpeg limit: %s\n", os.Args[2+i])
+			os.Exit(1)
+		}
+		*limit = value
+	}
+
+	if err := setLimits(limits); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	err := syscall.Exec(os.Args[5], os.Args[5:], os.Environ())
+	fmt.Fprintf(os.Stderr, "failed to exec ffmpeg: %v\n", err)
+	os.Exit(1)
+}
+
+func setLimits(limits FFmpegLimits) error {
+	rlimits := []struct {
+		resource  int
+		value     uint64
+		hardLimit uint64
+	}{
+		// The soft limit sends SIGXCPU, the hard limit one second later sends SIGKILL
+		{unix.RLIMIT_CPU, limits.CPUSeconds, limits.CPUSeconds + 1},
+		{unix.RLIMIT_AS, limits.AddressSpaceBytes, limits.AddressSpaceBytes},
+		{unix.RLIMIT_FSIZE, limits.FileSizeBytes, limits.FileSizeBytes},
+	}
+
+	for _, rlimit := range rlimits {
+		if rlimit.value == 0 {
+			continue
+		}
+
+		err := unix.Setrlimit(rlimit.resource, &unix.Rlimit{Cur: rlimit.value, Max: rlimit.hardLimit})
+		if err != nil {
+			return fmt.Errorf("failed to set rlimit %d: %w", rlimit.resource, err)
+		}
+	}
+
+	return nil
+}
+
+func killReason(state *os.ProcessState) string {
+	status, ok := state.Sys().(syscall.WaitStatus)
+	if !ok || !status.Signaled() {
+		return ""
+	}
+
+	switch status.Signal() {
+	case syscall.SIGXCPU:
+		return "cpu"
+	case syscall.SIGXFSZ:
+		return "file_size"
+	case syscall.SIGKILL:
+		return "killed"
+	case syscall.SIGSEGV, syscall.SIGABRT:
+		// Allocations failing past the address space limit usually end up here
+		return "memory"
+	default:
+		return status.Signal().String()
+	}
+}
@@ -0,0 +1,45 @@
+//go:build linux
+
+package services
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"regexp"
+	"testing"
+)
+
+// Runs cat in place of ffmpeg, which reads its own limits, so they must be in place from the moment it starts
+func TestFFmpegSandboxCommandLimitsBeforeExec(t *testing.T) {
+	if _, err := os.Stat("/bin/cat"); err != nil {
+		t.Skip("/bin/cat is missing")
+	}
+
+	sandbox := &FFmpegSandbox{
+		binaryPath: "/bin/cat",
+		limits: FFmpegLimits{
+			CPUSeconds:        7,
+			AddressSpaceBytes: 1 << 30,
+			FileSizeBytes:     1 << 20,
+		},
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd := sandbox.command(context.Background(), "/proc/self/limits")
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		t.Fatalf("failed to run command: %v: %s", err, stderr.String())
+	}
+
+	for _, limit := range []string{
+		`Max cpu time\s+7\s+8\s+seconds`,
+		`Max address space\s+1073741824\s+1073741824\s+bytes`,
+		`Max file size\s+1048576\s+1048576\s+bytes`,
+	} {
+		if !regexp.MustCompile(limit).Match(stdout.Bytes()) {
+			t.Errorf("limits lack %s:\n%s", limit, stdout.String())
+		}
+	}
+}
@@ -0,0 +1,20 @@
+//go:build !linux
+
+package services
+
+import (
+	"context"
+	"os"
+	"os/exec"
+)
+
+// Resource limits are only applied on Linux
+func (sandbox *FFmpegSandbox) command(ctx context.Context, args ...string) *exec.Cmd {
+	return exec.CommandContext(ctx, sandbox.binaryPath, args...)
+}
+
+func RunFFmpegShim() {}
+
+func killReason(state *os.ProcessState) string {
+	return ""
+}
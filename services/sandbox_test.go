@@ -0,0 +1,12 @@
+package services
+
+import (
+	"os"
+	"testing"
+)
+
+// Test binaries re-execute themselves as the ffmpeg shim just like the server does
+func TestMain(m *testing.M) {
+	RunFFmpegShim()
+	os.Exit(m.Run())
+}
@@ -0,0 +1,52 @@
+package services
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// URLBuilder resolves public object keys to URLs when building responses, so stored keys don't depend on the
+// endpoint or CDN serving them
+type URLBuilder interface {
+	URL(bucket Bucket, key string) string
+}
+
+// CDNURLBuilder serves buckets with a configured CDN base URL from the CDN and every other bucket from the blob store
+type CDNURLBuilder struct {
+	store    BlobStore
+	baseURLs map[Bucket]*url.URL
+}
+
+func NewCDNURLBuilder(store BlobStore) (*CDNURLBuilder, error) {
+	baseURLs := map[Bucket]*url.URL{}
+
+	for bucket, key := range map[Bucket]string{
+		BucketAudio: "CDN_AUDIO_BASE_URL",
+		BucketImage: "CDN_IMAGE_BASE_URL",
+	} {
+		baseURLString, ok := os.LookupEnv(key)
+		if !ok {
+			continue
+		}
+
+		baseURL, err := url.Parse(strings.TrimSuffix(baseURLString, "/"))
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", key, err)
+		}
+		baseURLs[bucket] = baseURL
+	}
+
+	return &CDNURLBuilder{
+		store:    store,
+		baseURLs: baseURLs,
+	}, nil
+}
+
+func (builder *CDNURLBuilder) URL(bucket Bucket, key string) string {
+	if baseURL, ok := builder.baseURLs[bucket]; ok {
+		return baseURL.JoinPath(key).String()
+	}
+	return builder.store.PublicURL(bucket, key)
+}
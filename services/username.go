@@ -0,0 +1,185 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+type UsernamePolicy struct {
+	MinLength int `json:"min_length"`
+	MaxLength int `json:"max_length"`
+	// Names that would impersonate the service or its staff, matched anywhere in a name after folding confusables.
+	// Names shorter than reservedSubstringMinLength only match the whole name, as they're part of too many words
+	Reserved []string `json:"reserved"`
+	// Words that may not appear anywhere in a name, compared after folding confusables
+	Blocked []string `json:"blocked"`
+	// Innocent words that contain a blocked or reserved word, like "scunthorpe". The words they contain don't count
+	Allowed []string `json:"allowed"`
+	// Maps look-alike character sequences to the letter they imitate, so "4dm1n" reads as "admin"
+	Confusables map[string]string `json:"confusables"`
+}
+
+type UsernamePolicyViolation struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// Only ASCII letters and digits, separated by single dashes or underscores. This also keeps out look-alike letters from
+// other scripts, which the confusables can't cover
+var usernameRegex = regexp.MustCompile(`^[a-zA-Z0-9]+(?:[-_][a-zA-Z0-9]+)*$`)
+
+var usernameSeparatorReplacer = strings.NewReplacer("-", "", "_", "")
+
+const reservedSubstringMinLength = 4
+
+func DefaultUsernamePolicy() UsernamePolicy {
+	return UsernamePolicy{
+		// Generated names are at most 35 characters long
+		MinLength: 3,
+		MaxLength: 40,
+		Reserved: []string{
+			"admin", "administrator", "moderator", "mod", "root", "system", "staff", "support", "help", "official",
+			"security", "billing", "api", "www", "null", "undefined", "anonymous", "deleted", "worldsounds",
+		},
+		Blocked: []string{
+			"fuck", "shit", "cunt", "bitch", "asshole", "bastard", "whore", "slut", "nigger", "nigga", "faggot", "retard",
+			"nazi", "hitler",
+		},
+		Allowed: []string{
+			"scunthorpe", "shitake", "retardant", "helpful", "helper", "whelp", "beetroot", "rooster",
+		},
+		Confusables: map[string]string{
+			"0":  "o",
+			"1":  "i",
+			"l":  "i",
+			"3":  "e",
+			"4":  "a",
+			"5":  "s",
+			"7":  "t",
+			"8":  "b",
+			"9":  "g",
+			"rn": "m",
+			"vv": "w",
+		},
+	}
+}
+
+// Starts from the default policy and overrides it with the JSON file at USERNAME_POLICY_FILE, then with the JSON in
+// USERNAME_POLICY
+func LoadUsernamePolicy() (UsernamePolicy, error) {
+	policy := DefaultUsernamePolicy()
+
+	if policyFile, ok := os.LookupEnv("USERNAME_POLICY_FILE"); ok {
+		policyBytes, err := os.ReadFile(policyFile)
+		if err != nil {
+			return policy, fmt.Errorf("failed to read username policy file: %w", err)
+		}
+
+		if err := json.Unmarshal(policyBytes, &policy); err != nil {
+			return policy, fmt.Errorf("failed to parse username policy file: %w", err)
+		}
+	}
+
+	if policyJSON, ok := os.LookupEnv("USERNAME_POLICY"); ok {
+		if err := json.Unmarshal([]byte(policyJSON), &policy); err != nil {
+			return policy, fmt.Errorf("failed to parse USERNAME_POLICY environment variable: %w", err)
+		}
+	}
+
+	if policy.MinLength <= 0 || policy.MaxLength < policy.MinLength {
+		return policy, errors.New("username policy lengths must be positive and max_length at least min_length")
+	}
+
+	return policy, nil
+}
+
+// Replaces the longest look-alike sequences first, so "rn" wins over its single letters
+func (policy UsernamePolicy) confusablesReplacer() *strings.Replacer {
+	sequences := make([]string, 0, len(policy.Confusables))
+	for sequence := range policy.Confusables {
+		sequences = append(sequences, sequence)
+	}
+	slices.SortFunc(sequences, func(a, b string) int {
+		if len(a) != len(b) {
+			return len(b) - len(a)
+		}
+		return strings.Compare(a, b)
+	})
+
+	pairs := make([]string, 0, 2*len(sequences))
+	for _, sequence := range sequences {
+		pairs = append(pairs, strings.ToLower(sequence), strings.ToLower(policy.Confusables[sequence]))
+	}
+	return strings.NewReplacer(pairs...)
+}
+
+// Lowercases the name, drops separators and folds confusables, so names that read the same compare equal
+func foldUsername(confusables *strings.Replacer, username string) string {
+	return confusables.Replace(usernameSeparatorReplacer.Replace(strings.ToLower(username)))
+}
+
+// Cuts allowed words out of the name, leaving a marker so the text around them doesn't join into a blocked word
+func (policy UsernamePolicy) allowedReplacer(confusables *strings.Replacer) *strings.Replacer {
+	pairs := make([]string, 0, 4*len(policy.Allowed))
+	for _, allowed := range policy.Allowed {
+		if allowed != "" {
+			pairs = append(pairs, strings.ToLower(allowed), "|", foldUsername(confusables, allowed), "|")
+		}
+	}
+	return strings.NewReplacer(pairs...)
+}
+
+func (policy UsernamePolicy) Check(username string) []UsernamePolicyViolation {
+	violations := []UsernamePolicyViolation{}
+
+	if len(username) < policy.MinLength || len(username) > policy.MaxLength {
+		violations = append(violations, UsernamePolicyViolation{"length", fmt.Sprintf("username must be between %d and %d characters long", policy.MinLength, policy.MaxLength)})
+	}
+
+	if !usernameRegex.MatchString(username) {
+		violations = append(violations, UsernamePolicyViolation{"charset", "username may only contain letters and digits, separated by single dashes or underscores"})
+		return violations
+	}
+
+	confusables := policy.confusablesReplacer()
+	folded := foldUsername(confusables, username)
+
+	// Folding confusables across separators turns innocent joins like "fish-17" into "fishit", so confusables are only
+	// folded within each part while the bare joined name catches words spelled out across separators like "fu-ck"
+	allowed := policy.allowedReplacer(confusables)
+	joined := allowed.Replace(usernameSeparatorReplacer.Replace(strings.ToLower(username)))
+	parts := strings.FieldsFunc(username, func(r rune) bool { return r == '-' || r == '_' })
+	for i, part := range parts {
+		parts[i] = allowed.Replace(foldUsername(confusables, part))
+	}
+	contains := func(word string) bool {
+		if word == "" {
+			return false
+		}
+		if strings.Contains(joined, strings.ToLower(word)) {
+			return true
+		}
+		word = foldUsername(confusables, word)
+		return slices.ContainsFunc(parts, func(part string) bool { return strings.Contains(part, word) })
+	}
+
+	if slices.ContainsFunc(policy.Reserved, func(reserved string) bool {
+		if foldUsername(confusables, reserved) == folded {
+			return true
+		}
+		return len(reserved) >= reservedSubstringMinLength && contains(reserved)
+	}) {
+		violations = append(violations, UsernamePolicyViolation{"reserved", "username is reserved"})
+	}
+
+	if slices.ContainsFunc(policy.Blocked, contains) {
+		violations = append(violations, UsernamePolicyViolation{"blocked", "username contains a blocked word"})
+	}
+
+	return violations
+}
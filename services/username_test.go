@@ -0,0 +1,52 @@
+package services
+
+import (
+	"testing"
+)
+
+func TestUsernamePolicyCheck(t *testing.T) {
+	policy := DefaultUsernamePolicy()
+
+	tests := []struct {
+		username string
+		want     string
+	}{
+		{"quiet-river", ""},
+		{"scunthorpe", ""},
+		{"shitake", ""},
+		{"fish-17", ""},
+		{"helpful-otter", ""},
+		{"rapid-mode", ""},
+		{"fuck", "blocked"},
+		{"sh1t-head", "blocked"},
+		{"fu-ck", "blocked"},
+		{"FuckYou", "blocked"},
+		{"fuckyou", "blocked"},
+		{"shithead", "blocked"},
+		{"bigshit", "blocked"},
+		{"shitakeshit", "blocked"},
+		{"4dm1n", "reserved"},
+		{"mod", "reserved"},
+		{"admin-team", "reserved"},
+		{"realadmin", "reserved"},
+		{"official_admin", "reserved"},
+		{"r34l-4dm1n", "reserved"},
+		{"ab", "length"},
+		{"bad--name", "charset"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.username, func(t *testing.T) {
+			violations := policy.Check(test.username)
+			if test.want == "" {
+				if len(violations) > 0 {
+					t.Errorf("got violations %v, want none", violations)
+				}
+				return
+			}
+			if len(violations) != 1 || violations[0].Code != test.want {
+				t.Errorf("got violations %v, want %s", violations, test.want)
+			}
+		})
+	}
+}
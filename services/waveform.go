@@ -0,0 +1,43 @@
+package services
+
+import "math"
+
+const waveformPixelsPerSecond = 50
+
+// Waveform follows the audiowaveform JSON format, with data holding a min and max pair per pixel
+type Waveform struct {
+	Version         int    `json:"version"`
+	Channels        int    `json:"channels"`
+	SampleRate      int    `json:"sample_rate"`
+	SamplesPerPixel int    `json:"samples_per_pixel"`
+	Bits            int    `json:"bits"`
+	Length          int    `json:"length"`
+	Data            []int8 `json:"data"`
+}
+
+func NewWaveform(samples []int16, sampleRate int) *Waveform {
+	samplesPerPixel := sampleRate / waveformPixelsPerSecond
+
+	data := make([]int8, 0, 2*(len(samples)/samplesPerPixel+1))
+	for start := 0; start < len(samples); start += samplesPerPixel {
+		end := min(start+samplesPerPixel, len(samples))
+
+		minSample, maxSample := int16(math.MaxInt16), int16(math.MinInt16)
+		for _, sample := range samples[start:end] {
+			minSample = min(minSample, sample)
+			maxSample = max(maxSample, sample)
+		}
+
+		data = append(data, int8(minSample>>8), int8(maxSample>>8))
+	}
+
+	return &Waveform{
+		Version:         2,
+		Channels:        1,
+		SampleRate:      sampleRate,
+		SamplesPerPixel: samplesPerPixel,
+		Bits:            8,
+		Length:          len(data) / 2,
+		Data:            data,
+	}
+}